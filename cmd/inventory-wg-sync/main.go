@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"os"
@@ -21,6 +22,9 @@ func main() {
 }
 
 func run() error {
+	force := flag.Bool("force", false, "apply the AllowedIPs changes even if max_removed or max_removed_percent is exceeded")
+	flag.Parse()
+
 	utils.SetLogger(logger)
 	path, err := xdg.SearchConfigFile("inventory-wg-sync.yml")
 	if err != nil {
@@ -35,6 +39,7 @@ func run() error {
 		return fmt.Errorf("cannot read the %s config file: %w", path, err)
 	}
 
+	cfg.Force = *force
 	utils.SetDebug(cfg.Debug)
 
 	if err := services.Sync(cfg); err != nil {
@@ -2,19 +2,121 @@ package models
 
 import (
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
+const (
+	ModeInclude = "include" // route inventory hosts and allowed_ips
+	ModeExcept  = "except"  // route allowed_ips (everything by default) except inventory hosts and excluded_ips
+
+	UnresolvableIgnore = "ignore" // drop unresolvable entries, mentioning them in debug output only
+	UnresolvableWarn   = "warn"   // drop unresolvable entries with a warning
+	UnresolvableFail   = "fail"   // abort the sync if any entry cannot be resolved
+)
+
 type Config struct {
-	InventoryPaths []string `yaml:"inventory_paths"` // ansible inventory paths
-	ProfilePath    string   `yaml:"profile_path"`    // wireguard profile path
-	AllowedIPs     []string `yaml:"allowed_ips"`     // allowed ips
-	ExcludedIPs    []string `yaml:"excluded_ips"`    // excluded ips
-	Table          int      `yaml:"table"`           // routing table
-	PostUp         []string `yaml:"post_up"`         // post up commands
-	PostDown       []string `yaml:"post_down"`       // post down commands
-	Debug          bool     `yaml:"debug"`
+	InventoryPaths      []string            `yaml:"inventory_paths"`      // ansible inventory paths
+	Inventories         []Inventory         `yaml:"inventories"`          // ansible inventory sources with explicit options
+	InventoryTimeout    time.Duration       `yaml:"inventory_timeout"`    // dynamic inventory script timeout
+	InventoriesRequired bool                `yaml:"inventories_required"` // abort the sync if any inventory cannot be read, unless the inventory's required is false
+	AnsibleInventory    bool                `yaml:"ansible_inventory"`    // load inventories like ansible does (ansible.cfg, group_vars, host_vars)
+	AnsibleCfg          string              `yaml:"ansible_cfg"`          // ansible.cfg path, used with ansible_inventory
+	IncludeGroups       []string            `yaml:"include_groups"`       // use only hosts of the groups matching these patterns
+	ExcludeGroups       []string            `yaml:"exclude_groups"`       // ignore hosts of the groups matching these patterns
+	AddressVars         []string            `yaml:"address_vars"`         // host vars to take the addresses from (first set wins), falls back to ansible_host
+	GroupAddressVars    map[string][]string `yaml:"group_address_vars"`   // per-group address_vars overrides
+	MaxTODOHosts        *int                `yaml:"max_todo_hosts"`       // fail if more hosts have TODO placeholders, no limit if not set
+	MaxRemoved          int                 `yaml:"max_removed"`          // refuse to remove more AllowedIPs from the profile at once, no limit if 0
+	MaxRemovedPercent   float64             `yaml:"max_removed_percent"`  // refuse to remove a bigger share (in percent) of the profile AllowedIPs at once, no limit if 0
+	Mode                string              `yaml:"mode"`                 // include (default) or except
+	ProfilePath         string              `yaml:"profile_path"`         // wireguard profile path
+	AllowedIPs          []string            `yaml:"allowed_ips"`          // allowed ips
+	ExcludedIPs         []string            `yaml:"excluded_ips"`         // excluded ips
+	Feeds               []Feed              `yaml:"feeds"`                // published IP ranges of cloud and CDN providers to include
+	DNS                 DNS                 `yaml:"dns"`                  // hostname resolution options
+	HTTP                HTTP                `yaml:"http"`                 // options of http(s) URL sources
+	Unresolvable        Unresolvable        `yaml:"unresolvable"`         // what to do with entries that cannot be resolved, per source
+	ExactAllowedIPs     bool                `yaml:"exact_allowed_ips"`    // keep AllowedIPs as collected, without merging them into the minimal set of CIDRs
+	MaxAllowedIPs       int                 `yaml:"max_allowed_ips"`      // widen AllowedIPs until they fit into this number of entries, no limit if 0
+	MinPrefixIPv4       int                 `yaml:"min_prefix_ipv4"`      // the shortest IPv4 prefix max_allowed_ips may widen to, 16 by default
+	MinPrefixIPv6       int                 `yaml:"min_prefix_ipv6"`      // the shortest IPv6 prefix max_allowed_ips may widen to, 48 by default
+	Table               int                 `yaml:"table"`                // routing table
+	PostUp              []string            `yaml:"post_up"`              // post up commands
+	PostDown            []string            `yaml:"post_down"`            // post down commands
+	Debug               bool                `yaml:"debug"`
+	Force               bool                `yaml:"-"` // apply changes even if max_removed or max_removed_percent is exceeded, set by the --force flag
+}
+
+// Inventory is an ansible inventory source
+type Inventory struct {
+	Path         string   `yaml:"path"`         // inventory file, dynamic inventory script, or Terraform state path
+	Type         string   `yaml:"type"`         // ini, yaml, script, or tfstate; detected automatically if empty
+	Required     *bool    `yaml:"required"`     // abort the sync if the inventory cannot be read, defaults to inventories_required
+	Unresolvable string   `yaml:"unresolvable"` // ignore, warn, or fail on host addresses that cannot be resolved, defaults to unresolvable.inventories
+	Attributes   []string `yaml:"attributes"`   // tfstate: "resource_type.attribute" paths to take the addresses from
+}
+
+// Feed is a published IP ranges document of a cloud or CDN provider
+type Feed struct {
+	Source   string   `yaml:"source"`   // file path or http(s) URL
+	Format   string   `yaml:"format"`   // aws, gcp, azure, or plain (one CIDR per line); detected automatically if empty
+	Services []string `yaml:"services"` // use only the ranges of the services matching these patterns, all if empty
+	Regions  []string `yaml:"regions"`  // use only the ranges of the regions matching these patterns, all if empty
+	Required bool     `yaml:"required"` // abort the sync if the feed cannot be read
+}
+
+// DNS is the hostname resolution config
+type DNS struct {
+	Nameservers []string      `yaml:"nameservers"` // nameservers (IP or IP:port) to use instead of the system ones
+	Protocol    string        `yaml:"protocol"`    // udp or tcp, the system default if empty
+	NoSearch    bool          `yaml:"no_search"`   // treat hostnames as fully qualified, without applying search domains
+	Workers     int           `yaml:"workers"`     // max concurrent lookups, 16 by default
+	Timeout     time.Duration `yaml:"timeout"`     // per-lookup timeout, 5s by default
+	Deadline    time.Duration `yaml:"deadline"`    // deadline for all lookups of the sync, 2m by default
+	StatePath   string        `yaml:"state_path"`  // file to keep the last known good lookup results in, disabled if empty
+	Grace       time.Duration `yaml:"grace"`       // how long since the lookup the results are used if lookups fail, 24h by default
+}
+
+// Unresolvable is the policy (ignore, warn, or fail) for entries that cannot be resolved, ignore by default
+type Unresolvable struct {
+	AllowedIPs  string `yaml:"allowed_ips"`  // policy for allowed_ips entries
+	ExcludedIPs string `yaml:"excluded_ips"` // policy for excluded_ips entries
+	Inventories string `yaml:"inventories"`  // policy for inventory host addresses, unless the inventory's unresolvable is set
+}
+
+// UnresolvablePolicy returns the policy for host addresses that cannot be resolved, falling back to the global unresolvable.inventories
+func (i Inventory) UnresolvablePolicy(global string) string {
+	if i.Unresolvable != "" {
+		return i.Unresolvable
+	}
+	return global
+}
+
+// HTTP is the config of downloading http(s) URL sources: inventories, feeds, and allowed_ips and excluded_ips lists
+type HTTP struct {
+	Timeout    time.Duration     `yaml:"timeout"`     // request timeout, 30s by default
+	CacheDir   string            `yaml:"cache_dir"`   // dir to keep the downloaded copies in, to use them when the server is unavailable
+	Grace      time.Duration     `yaml:"grace"`       // how long the cached copy may be used when the server is unavailable, 24h by default
+	TokenFiles map[string]string `yaml:"token_files"` // URL host -> file with the bearer token to send to it
+}
+
+// IsRequired tells if the inventory must be readable, falling back to the global inventories_required
+func (i Inventory) IsRequired(global bool) bool {
+	if i.Required != nil {
+		return *i.Required
+	}
+	return global
+}
+
+// AllInventories returns inventory sources from both inventory_paths and inventories
+func (c *Config) AllInventories() []Inventory {
+	all := make([]Inventory, 0, len(c.InventoryPaths)+len(c.Inventories))
+	for _, path := range c.InventoryPaths {
+		all = append(all, Inventory{Path: path})
+	}
+	return append(all, c.Inventories...)
 }
 
 // Read config from file system
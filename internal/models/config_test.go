@@ -5,6 +5,7 @@ import (
 	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestRead(t *testing.T) {
@@ -13,11 +14,69 @@ func TestRead(t *testing.T) {
 	contents := `
 inventory_paths:
   - /etc/ansible/hosts
+inventories:
+  - path: /etc/ansible/inventory.py
+    type: script
+    required: false
+    unresolvable: fail
+  - path: /srv/terraform/terraform.tfstate
+    attributes:
+      - hcloud_server.ipv4_address
+inventory_timeout: 1m
+inventories_required: true
+ansible_inventory: true
+ansible_cfg: /etc/ansible/ansible.cfg
+include_groups:
+  - prod:&eu
+exclude_groups:
+  - legacy
+address_vars:
+  - public_ipv4
+group_address_vars:
+  internal:
+    - private_ip
+max_todo_hosts: 3
+max_removed: 10
+max_removed_percent: 25.5
+mode: except
 profile_path: /etc/wireguard/wg0.conf
 allowed_ips:
   - 10.0.0.0/8
 excluded_ips:
   - 10.10.0.0/16
+feeds:
+  - source: https://ip-ranges.amazonaws.com/ip-ranges.json
+    format: aws
+    services:
+      - CLOUDFRONT
+    regions:
+      - GLOBAL
+    required: true
+dns:
+  nameservers:
+    - 1.1.1.1
+    - "[::1]:5353"
+  protocol: tcp
+  no_search: true
+  workers: 32
+  timeout: 2s
+  deadline: 1m
+  state_path: /var/lib/inventory-wg-sync/dns.json
+  grace: 12h
+http:
+  timeout: 10s
+  cache_dir: /var/cache/inventory-wg-sync
+  grace: 6h
+  token_files:
+    intranet.example.com: /etc/inventory-wg-sync/token
+unresolvable:
+  allowed_ips: warn
+  excluded_ips: fail
+  inventories: ignore
+exact_allowed_ips: true
+max_allowed_ips: 300
+min_prefix_ipv4: 20
+min_prefix_ipv6: 56
 table: 1234
 post_up:
   - echo up
@@ -34,15 +93,41 @@ debug: true
 		t.Fatalf("Read() error = %v", err)
 	}
 
+	maxTODOHosts := 3
+	optional := false
 	want := &Config{
 		InventoryPaths: []string{"/etc/ansible/hosts"},
-		ProfilePath:    "/etc/wireguard/wg0.conf",
-		AllowedIPs:     []string{"10.0.0.0/8"},
-		ExcludedIPs:    []string{"10.10.0.0/16"},
-		Table:          1234,
-		PostUp:         []string{"echo up"},
-		PostDown:       []string{"echo down"},
-		Debug:          true,
+		Inventories: []Inventory{
+			{Path: "/etc/ansible/inventory.py", Type: "script", Required: &optional, Unresolvable: UnresolvableFail},
+			{Path: "/srv/terraform/terraform.tfstate", Attributes: []string{"hcloud_server.ipv4_address"}},
+		},
+		InventoryTimeout:    time.Minute,
+		InventoriesRequired: true,
+		AnsibleInventory:    true,
+		AnsibleCfg:          "/etc/ansible/ansible.cfg",
+		IncludeGroups:       []string{"prod:&eu"},
+		ExcludeGroups:       []string{"legacy"},
+		AddressVars:         []string{"public_ipv4"},
+		GroupAddressVars:    map[string][]string{"internal": {"private_ip"}},
+		MaxTODOHosts:        &maxTODOHosts,
+		MaxRemoved:          10,
+		MaxRemovedPercent:   25.5,
+		Mode:                ModeExcept,
+		ProfilePath:         "/etc/wireguard/wg0.conf",
+		AllowedIPs:          []string{"10.0.0.0/8"},
+		ExcludedIPs:         []string{"10.10.0.0/16"},
+		Feeds:               []Feed{{Source: "https://ip-ranges.amazonaws.com/ip-ranges.json", Format: "aws", Services: []string{"CLOUDFRONT"}, Regions: []string{"GLOBAL"}, Required: true}},
+		DNS:                 DNS{Nameservers: []string{"1.1.1.1", "[::1]:5353"}, Protocol: "tcp", NoSearch: true, Workers: 32, Timeout: 2 * time.Second, Deadline: time.Minute, StatePath: "/var/lib/inventory-wg-sync/dns.json", Grace: 12 * time.Hour},
+		HTTP:                HTTP{Timeout: 10 * time.Second, CacheDir: "/var/cache/inventory-wg-sync", Grace: 6 * time.Hour, TokenFiles: map[string]string{"intranet.example.com": "/etc/inventory-wg-sync/token"}},
+		Unresolvable:        Unresolvable{AllowedIPs: UnresolvableWarn, ExcludedIPs: UnresolvableFail, Inventories: UnresolvableIgnore},
+		ExactAllowedIPs:     true,
+		MaxAllowedIPs:       300,
+		MinPrefixIPv4:       20,
+		MinPrefixIPv6:       56,
+		Table:               1234,
+		PostUp:              []string{"echo up"},
+		PostDown:            []string{"echo down"},
+		Debug:               true,
 	}
 
 	if !reflect.DeepEqual(got, want) {
@@ -66,3 +151,33 @@ func TestRead_InvalidYAML(t *testing.T) {
 		t.Fatalf("Read() expected error for invalid YAML")
 	}
 }
+
+func TestAllInventories(t *testing.T) {
+	cfg := &Config{
+		InventoryPaths: []string{"/etc/ansible/hosts"},
+		Inventories:    []Inventory{{Path: "/etc/ansible/inventory.py", Type: "script"}},
+	}
+	want := []Inventory{{Path: "/etc/ansible/hosts"}, {Path: "/etc/ansible/inventory.py", Type: "script"}}
+	if got := cfg.AllInventories(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("AllInventories() = %#v, want %#v", got, want)
+	}
+}
+
+func TestInventoryIsRequired(t *testing.T) {
+	required, optional := true, false
+	if (Inventory{}).IsRequired(false) || !(Inventory{}).IsRequired(true) {
+		t.Fatalf("IsRequired() should fall back to the global setting")
+	}
+	if !(Inventory{Required: &required}).IsRequired(false) || (Inventory{Required: &optional}).IsRequired(true) {
+		t.Fatalf("IsRequired() should prefer the inventory setting")
+	}
+}
+
+func TestInventoryUnresolvablePolicy(t *testing.T) {
+	if got := (Inventory{}).UnresolvablePolicy(UnresolvableWarn); got != UnresolvableWarn {
+		t.Fatalf("UnresolvablePolicy() = %q, want the global setting", got)
+	}
+	if got := (Inventory{Unresolvable: UnresolvableFail}).UnresolvablePolicy(UnresolvableWarn); got != UnresolvableFail {
+		t.Fatalf("UnresolvablePolicy() = %q, want the inventory setting", got)
+	}
+}
@@ -1,89 +1,378 @@
 package services
 
 import (
-	"github.com/etkecc/go-ansible"
-	"github.com/etkecc/go-kit"
+	"errors"
+	"fmt"
+	"maps"
+	"math/big"
+	"net/netip"
+	"slices"
 
 	"github.com/etkecc/inventory-wg-sync/internal/models"
 	"github.com/etkecc/inventory-wg-sync/internal/utils"
 )
 
-func AllowedIPs(cfg *models.Config) []string {
-	allowedIPs, excludedIPs := configIPs(cfg)
-	for _, invPath := range cfg.InventoryPaths {
-		allowedIPs = append(allowedIPs, inventoryIPs(invPath, excludedIPs)...)
+const (
+	sourceAllowedIPs  = "allowed_ips"  // the source of the allowed_ips config entries
+	sourceExcludedIPs = "excluded_ips" // the source of the excluded_ips config entries
+
+	defaultMinPrefixIPv4 = 16
+	defaultMinPrefixIPv6 = 48
+)
+
+var (
+	// exceptModeIPs are the allowed IPs of the except mode if allowed_ips is empty
+	exceptModeIPs = []string{"0.0.0.0/0", "::/0"}
+
+	// errUnresolvable is returned for entries that cannot be resolved under the fail policy
+	errUnresolvable = errors.New("cannot be resolved")
+)
+
+// Result is the AllowedIPs list with the counters collected while building it
+type Result struct {
+	AllowedIPs []netip.Prefix
+	Sources    Sources // where the allowed IPs come from
+	Hosts      int     // inventory hosts used
+	Skipped    int     // inventory hosts skipped by group filters or wg_sync_skip
+	TODOs      int     // inventory hosts skipped due to TODO placeholders
+}
+
+// lookup is an entry to resolve: an excluded_ips or allowed_ips entry of the config, or an address of the inventory host
+type lookup struct {
+	entry  string         // IP, CIDR, range, or hostname
+	source string         // excluded_ips, allowed_ips, or the inventory host name
+	host   bool           // the entry is an address of the inventory host
+	label  string         // the source in the unresolvable messages
+	policy string         // the unresolvable policy of the entry
+	cidrs  []netip.Prefix // the resolved CIDRs, see resolveLookups
+}
+
+// Sources maps the collected CIDRs to their sources: inventory host names or allowed_ips
+type Sources map[netip.Prefix][]string
+
+func AllowedIPs(cfg *models.Config) (*Result, error) {
+	if err := validateConfig(cfg); err != nil {
+		return nil, err
+	}
+	hr, err := newHostResolver(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer saveDNSState(hr)
+
+	res := &Result{Sources: Sources{}}
+	lookups, err := configLookups(cfg)
+	if err != nil {
+		return nil, err
+	}
+	hostLookups, err := inventoriesLookups(cfg, res)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.MaxTODOHosts != nil && res.TODOs > *cfg.MaxTODOHosts {
+		return nil, fmt.Errorf("%d hosts have TODO placeholders, max_todo_hosts is %d", res.TODOs, *cfg.MaxTODOHosts)
+	}
+	// the entries of all sources are resolved at once, after the remote lists are fetched and the inventories are read
+	lookups = append(lookups, hostLookups...)
+	if err = resolveLookups(cfg, hr, lookups); err != nil {
+		return nil, err
+	}
+
+	excludedIPs := configCIDRs(lookups, sourceExcludedIPs)
+	allowedIPs := utils.SubtractCIDRs(configCIDRs(lookups, sourceAllowedIPs), excludedIPs)
+	res.Sources.add(sourceAllowedIPs, allowedIPs...)
+	feedIPs, err := feedsIPs(cfg, excludedIPs, res)
+	if err != nil {
+		return nil, err
+	}
+	allowedIPs = append(allowedIPs, feedIPs...)
+	hostIPs := hostsIPs(cfg, lookups, excludedIPs, res)
+
+	if cfg.Mode == models.ModeExcept {
+		allowedIPs = utils.SubtractCIDRs(allowedIPs, hostIPs)
+		excludedIPs = append(excludedIPs, hostIPs...)
+	} else {
+		allowedIPs = append(allowedIPs, hostIPs...)
 	}
-	allowedIPs = kit.Uniq(allowedIPs)
-	utils.SortIPs(allowedIPs)
-	return allowedIPs
+	allowedIPs, err = finalizeAllowedIPs(cfg, allowedIPs, excludedIPs)
+	if err != nil {
+		return nil, err
+	}
+	res.AllowedIPs = allowedIPs
+	return res, nil
 }
 
-func configIPs(cfg *models.Config) (allowedIPs []string, excludedIPs map[string]bool) {
-	excludedIPs = collectExcludedIPs(cfg.ExcludedIPs)
-	allowedIPs = collectAllowedIPs(cfg.AllowedIPs, excludedIPs)
-	return allowedIPs, excludedIPs
+// finalizeAllowedIPs sorts and deduplicates allowed IPs, summarizes them (unless exact_allowed_ips is set),
+// and coarsens them to fit max_allowed_ips
+func finalizeAllowedIPs(cfg *models.Config, allowedIPs, excludedIPs []netip.Prefix) ([]netip.Prefix, error) {
+	utils.SortPrefixes(allowedIPs)
+	allowedIPs = slices.Compact(allowedIPs)
+	if !cfg.ExactAllowedIPs {
+		allowedIPs = utils.SummarizeCIDRs(allowedIPs)
+	}
+	if cfg.MaxAllowedIPs <= 0 || len(allowedIPs) <= cfg.MaxAllowedIPs {
+		return allowedIPs, nil
+	}
+	// the limit applies to the CIDRs written into the profile, the unsupported families are filtered out later
+	ipv4, ipv6 := profileFamilies(cfg)
+	supported, unsupported := splitFamilies(allowedIPs, ipv4, ipv6)
+	if len(supported) <= cfg.MaxAllowedIPs {
+		return allowedIPs, nil
+	}
+
+	minBits4, minBits6 := cfg.MinPrefixIPv4, cfg.MinPrefixIPv6
+	if minBits4 <= 0 {
+		minBits4 = defaultMinPrefixIPv4
+	}
+	if minBits6 <= 0 {
+		minBits6 = defaultMinPrefixIPv6
+	}
+	coarse, ok := utils.CoarsenCIDRs(supported, excludedIPs, cfg.MaxAllowedIPs, minBits4, minBits6)
+	if !ok {
+		return nil, fmt.Errorf("cannot fit %d AllowedIPs into max_allowed_ips (%d) without widening them beyond /%d (IPv4) and /%d (IPv6) or covering excluded IPs", len(supported), cfg.MaxAllowedIPs, minBits4, minBits6)
+	}
+
+	exact := utils.SummarizeCIDRs(supported)
+	extra4 := new(big.Int).Sub(utils.AddressCount(coarse, true), utils.AddressCount(exact, true))
+	extra6 := new(big.Int).Sub(utils.AddressCount(coarse, false), utils.AddressCount(exact, false))
+	utils.Log("coarsened", len(supported), "AllowedIPs into", len(coarse), "to fit max_allowed_ips, including", extra4, "extra IPv4 and", extra6, "extra IPv6 addresses")
+	coarse = append(coarse, unsupported...)
+	utils.SortPrefixes(coarse)
+	return coarse, nil
 }
 
-func collectExcludedIPs(excluded []string) map[string]bool {
-	excludedIPs := map[string]bool{}
-	for _, ip := range excluded {
-		cidrs := utils.DetermineCIDRs(ip)
-		if len(cidrs) == 0 {
-			utils.Debug("excluded IP", ip, "is not an IP address")
-			continue
+// splitFamilies splits the CIDRs into the ones of the supported address families and the rest
+func splitFamilies(prefixes []netip.Prefix, ipv4, ipv6 bool) (supported, unsupported []netip.Prefix) {
+	for _, prefix := range prefixes {
+		if (prefix.Addr().Is4() && ipv4) || (prefix.Addr().Is6() && ipv6) {
+			supported = append(supported, prefix)
+		} else {
+			unsupported = append(unsupported, prefix)
 		}
-		for _, cidr := range cidrs {
-			excludedIPs[cidr] = true
+	}
+	return supported, unsupported
+}
+
+// add records the source of the CIDRs
+func (s Sources) add(source string, prefixes ...netip.Prefix) {
+	if s == nil {
+		return
+	}
+	for _, prefix := range prefixes {
+		if !slices.Contains(s[prefix], source) {
+			s[prefix] = append(s[prefix], source)
+		}
+	}
+}
+
+// of returns the sorted sources of the CIDRs overlapping with the prefix,
+// so the sources of a summarized CIDR include the sources of all CIDRs merged into it
+func (s Sources) of(prefix netip.Prefix) []string {
+	sources := []string{}
+	for collected, names := range s {
+		if collected.Overlaps(prefix) {
+			sources = append(sources, names...)
 		}
 	}
-	return excludedIPs
+	slices.Sort(sources)
+	return slices.Compact(sources)
 }
 
-func collectAllowedIPs(allowed []string, excludedIPs map[string]bool) []string {
-	result := make([]string, 0, len(allowed))
-	for _, ip := range allowed {
-		cidrs := utils.DetermineCIDRs(ip)
+// configLookups returns the excluded_ips and allowed_ips entries of the config to resolve, with http(s) lists expanded.
+// In the except mode, allowed IPs default to all IPv4 and IPv6 addresses
+func configLookups(cfg *models.Config) ([]lookup, error) {
+	allowed := cfg.AllowedIPs
+	if cfg.Mode == models.ModeExcept && len(allowed) == 0 {
+		allowed = exceptModeIPs
+	}
+	excluded, err := expandRemoteEntries(cfg, cfg.ExcludedIPs, cfg.Unresolvable.ExcludedIPs, sourceExcludedIPs)
+	if err != nil {
+		return nil, err
+	}
+	allowed, err = expandRemoteEntries(cfg, allowed, cfg.Unresolvable.AllowedIPs, sourceAllowedIPs)
+	if err != nil {
+		return nil, err
+	}
+
+	lookups := make([]lookup, 0, len(excluded)+len(allowed))
+	for _, entry := range excluded {
+		lookups = append(lookups, lookup{entry: entry, source: sourceExcludedIPs, label: sourceExcludedIPs, policy: cfg.Unresolvable.ExcludedIPs})
+	}
+	for _, entry := range allowed {
+		lookups = append(lookups, lookup{entry: entry, source: sourceAllowedIPs, label: sourceAllowedIPs, policy: cfg.Unresolvable.AllowedIPs})
+	}
+	return lookups, nil
+}
+
+// resolveLookups resolves the entries of all lookups in one pass of the resolver's worker pool, limited by the dns.deadline,
+// and applies the unresolvable policies to the entries that resolved to no CIDRs
+func resolveLookups(cfg *models.Config, hr *utils.HostResolver, lookups []lookup) error {
+	ctx, cancel := dnsContext(cfg)
+	defer cancel()
+
+	entries := make([]string, 0, len(lookups))
+	for _, l := range lookups {
+		entries = append(entries, l.entry)
+	}
+	for i, cidrs := range hr.DetermineCIDRs(ctx, entries) {
 		if len(cidrs) == 0 {
-			utils.Debug("allowed IP", ip, "is not an IP address")
+			if err := unresolvable(lookups[i].policy, lookups[i].label, lookups[i].entry); err != nil {
+				return err
+			}
+		}
+		lookups[i].cidrs = cidrs
+	}
+	return nil
+}
+
+// configCIDRs returns the resolved CIDRs of the config entries of the source
+func configCIDRs(lookups []lookup, source string) []netip.Prefix {
+	cidrs := []netip.Prefix{}
+	for _, l := range lookups {
+		if !l.host && l.source == source {
+			cidrs = append(cidrs, l.cidrs...)
+		}
+	}
+	return cidrs
+}
+
+// validateConfig checks the mode and the unresolvable policies of the config and its inventories
+func validateConfig(cfg *models.Config) error {
+	if cfg.Mode != "" && cfg.Mode != models.ModeInclude && cfg.Mode != models.ModeExcept {
+		return fmt.Errorf("unknown mode %q, must be %s or %s", cfg.Mode, models.ModeInclude, models.ModeExcept)
+	}
+	policies := map[string]string{
+		"unresolvable.allowed_ips":  cfg.Unresolvable.AllowedIPs,
+		"unresolvable.excluded_ips": cfg.Unresolvable.ExcludedIPs,
+		"unresolvable.inventories":  cfg.Unresolvable.Inventories,
+	}
+	for _, src := range cfg.Inventories {
+		policies["unresolvable of inventory "+src.Path] = src.Unresolvable
+	}
+	for _, key := range slices.Sorted(maps.Keys(policies)) {
+		switch policies[key] {
+		case "", models.UnresolvableIgnore, models.UnresolvableWarn, models.UnresolvableFail:
+		default:
+			return fmt.Errorf("unknown %s policy %q, must be %s, %s, or %s", key, policies[key], models.UnresolvableIgnore, models.UnresolvableWarn, models.UnresolvableFail)
+		}
+	}
+	return nil
+}
+
+// unresolvable handles the entry of the source that resolved to no CIDRs according to the policy:
+// returns an error with the fail policy, logs a warning with the warn policy, and mentions it in debug output otherwise
+func unresolvable(policy, source, entry string) error {
+	switch policy {
+	case models.UnresolvableFail:
+		return fmt.Errorf("%s entry %s %w", source, entry, errUnresolvable)
+	case models.UnresolvableWarn:
+		utils.Log("WARNING:", source, "entry", entry, "cannot be resolved, skipping")
+	default:
+		utils.Debug(source, "entry", entry, "cannot be resolved, skipping")
+	}
+	return nil
+}
+
+// inventorySources expands the inventory sources and, with ansible_inventory enabled, adds the inventories listed in their ansible.cfg files.
+// Errors are returned for required sources only, and logged for the optional ones
+func inventorySources(cfg *models.Config) ([]models.Inventory, error) {
+	srcs := []models.Inventory{}
+	for _, src := range cfg.AllInventories() {
+		expanded, err := expandInventory(src)
+		if err == nil && len(expanded) == 0 {
+			err = errors.New("no inventory files found")
+		}
+		if err != nil {
+			if err = inventoryError(src.Path, src.IsRequired(cfg.InventoriesRequired), err); err != nil {
+				return nil, err
+			}
 			continue
 		}
-		for _, cidr := range cidrs {
-			if !excludedIPs[cidr] {
-				result = append(result, cidr)
+		srcs = append(srcs, expanded...)
+	}
+	if !cfg.AnsibleInventory {
+		return srcs, nil
+	}
+	extra, err := ansibleCfgInventories(cfg, srcs)
+	if err != nil {
+		return nil, err
+	}
+	return append(srcs, extra...), nil
+}
+
+// inventoriesLookups reads all inventories and returns the addresses of their hosts to resolve.
+// Errors are returned for required inventories only, and logged for the optional ones
+func inventoriesLookups(cfg *models.Config, res *Result) ([]lookup, error) {
+	srcs, err := inventorySources(cfg)
+	if err != nil {
+		return nil, err
+	}
+	lookups := []lookup{}
+	for _, src := range srcs {
+		srcLookups, err := inventoryLookups(cfg, src, res)
+		if err != nil {
+			if err = inventoryError(src.Path, src.IsRequired(cfg.InventoriesRequired), err); err != nil {
+				return nil, err
 			}
+			continue
 		}
+		lookups = append(lookups, srcLookups...)
+	}
+	return lookups, nil
+}
+
+// inventoryError returns the error for required inventories, and logs it for optional ones
+func inventoryError(path string, required bool, err error) error {
+	if required {
+		return fmt.Errorf("cannot read required inventory %s: %w", path, err)
 	}
-	return result
+	utils.Log("ERROR: cannot read inventory", path, ":", err)
+	return nil
 }
 
-func inventoryIPs(path string, excludedIPs map[string]bool) []string {
-	inv, err := ansible.NewHostsFile(path, &ansible.Host{})
+// inventoryLookups reads the inventory and returns the addresses of its usable hosts to resolve
+func inventoryLookups(cfg *models.Config, src models.Inventory, res *Result) ([]lookup, error) {
+	inv, err := readInventory(cfg, src)
 	if err != nil {
-		utils.Log("ERROR: cannot read inventory file", path, ":", err)
-		return nil
+		return nil, err
 	}
 	if inv == nil || len(inv.Hosts) == 0 {
-		utils.Debug("inventory", path, "is empty")
-		return nil
+		utils.Debug("inventory", src.Path, "is empty")
+		return nil, nil
 	}
-	allowed := make([]string, 0, len(inv.Hosts))
-	for _, host := range inv.Hosts {
-		allowed = append(allowed, hostAllowedIPs(host.Host, excludedIPs)...)
+
+	policy := src.UnresolvablePolicy(cfg.Unresolvable.Inventories)
+	lookups := make([]lookup, 0, len(inv.Hosts))
+	for _, name := range slices.Sorted(maps.Keys(inv.Hosts)) {
+		for _, address := range usableHostAddresses(cfg, inv.Hosts[name], res) {
+			lookups = append(lookups, lookup{entry: address, source: name, host: true, label: "host " + name + " of inventory " + src.Path, policy: policy})
+		}
 	}
-	return allowed
+	return lookups, nil
 }
 
-func hostAllowedIPs(host string, excludedIPs map[string]bool) []string {
-	cidrs := utils.DetermineCIDRs(host)
-	if len(cidrs) == 0 {
-		utils.Debug("host", host, "is not an IP address")
-		return nil
+// hostsIPs returns the resolved CIDRs of the inventory hosts, with the excluded ranges carved out of them
+// (except for the except mode, where the hosts are subtracted from the allowed IPs anyway)
+func hostsIPs(cfg *models.Config, lookups []lookup, excludedIPs []netip.Prefix, res *Result) []netip.Prefix {
+	except := cfg.Mode == models.ModeExcept
+	if except {
+		excludedIPs = nil
 	}
-	allowed := make([]string, 0, len(cidrs))
-	for _, cidr := range cidrs {
-		if !excludedIPs[cidr] {
-			allowed = append(allowed, cidr)
+	allowed := []netip.Prefix{}
+	for _, l := range lookups {
+		if !l.host || len(l.cidrs) == 0 {
+			continue
 		}
+		ips := hostAllowedIPs(l.cidrs, excludedIPs)
+		if !except { // hosts are not routed in the except mode
+			res.Sources.add(l.source, ips...)
+		}
+		allowed = append(allowed, ips...)
 	}
 	return allowed
 }
+
+// hostAllowedIPs carves the excluded ranges out of the resolved host CIDRs
+func hostAllowedIPs(cidrs, excludedIPs []netip.Prefix) []netip.Prefix {
+	return utils.SubtractCIDRs(cidrs, excludedIPs)
+}
@@ -1,24 +1,130 @@
 package services
 
 import (
+	"errors"
+	"net/netip"
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
 
 	"github.com/etkecc/inventory-wg-sync/internal/models"
+	"github.com/etkecc/inventory-wg-sync/internal/utils"
 )
 
-func TestConfigIPs(t *testing.T) {
+func parsePrefixes(cidrs ...string) []netip.Prefix {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		prefixes = append(prefixes, netip.MustParsePrefix(cidr))
+	}
+	return prefixes
+}
+
+func prefixStrings(prefixes []netip.Prefix) []string {
+	cidrs := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		cidrs = append(cidrs, prefix.String())
+	}
+	return cidrs
+}
+
+// inventoryAllowedIPs returns the exact AllowedIPs of the inventory
+func inventoryAllowedIPs(t *testing.T, cfg *models.Config, src models.Inventory) []string {
+	t.Helper()
+	cfg.Inventories = []models.Inventory{src}
+	cfg.ExactAllowedIPs = true
+	res, err := AllowedIPs(cfg)
+	if err != nil {
+		t.Fatalf("AllowedIPs() error = %v", err)
+	}
+	return prefixStrings(res.AllowedIPs)
+}
+
+func TestConfigLookups(t *testing.T) {
 	cfg := &models.Config{
 		AllowedIPs:  []string{"1.2.3.4", "10.0.0.0/8", "bad_host"},
 		ExcludedIPs: []string{"1.2.3.4", "10.0.0.0/8", "also_bad"},
 	}
-	allowed, excluded := configIPs(cfg)
-	if len(allowed) != 0 {
-		t.Fatalf("configIPs() allowed = %#v, want empty", allowed)
+	lookups, err := configLookups(cfg)
+	if err != nil {
+		t.Fatalf("configLookups() error = %v", err)
 	}
-	if !excluded["1.2.3.4/32"] || !excluded["10.0.0.0/8"] {
-		t.Fatalf("configIPs() excluded missing expected entries: %#v", excluded)
+	if err := resolveLookups(cfg, &utils.HostResolver{}, lookups); err != nil {
+		t.Fatalf("resolveLookups() error = %v", err)
+	}
+	if want := []string{"1.2.3.4/32", "10.0.0.0/8"}; !reflect.DeepEqual(prefixStrings(configCIDRs(lookups, sourceAllowedIPs)), want) {
+		t.Fatalf("configCIDRs() allowed = %#v, want %#v", configCIDRs(lookups, sourceAllowedIPs), want)
+	}
+	if want := []string{"1.2.3.4/32", "10.0.0.0/8"}; !reflect.DeepEqual(prefixStrings(configCIDRs(lookups, sourceExcludedIPs)), want) {
+		t.Fatalf("configCIDRs() excluded = %#v, want %#v", configCIDRs(lookups, sourceExcludedIPs), want)
+	}
+
+	got, err := AllowedIPs(cfg)
+	if err != nil {
+		t.Fatalf("AllowedIPs() error = %v", err)
+	}
+	if len(got.AllowedIPs) != 0 {
+		t.Fatalf("AllowedIPs() = %#v, want empty", got.AllowedIPs)
+	}
+}
+
+func TestConfigLookups_Unresolvable(t *testing.T) {
+	tests := []struct {
+		name         string
+		unresolvable models.Unresolvable
+		wantErr      bool
+	}{
+		{name: "default"},
+		{name: "warn", unresolvable: models.Unresolvable{AllowedIPs: models.UnresolvableWarn, ExcludedIPs: models.UnresolvableWarn}},
+		{name: "fail allowed", unresolvable: models.Unresolvable{AllowedIPs: models.UnresolvableFail}, wantErr: true},
+		{name: "fail excluded", unresolvable: models.Unresolvable{ExcludedIPs: models.UnresolvableFail}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &models.Config{
+				AllowedIPs:   []string{"10.0.0.0/8", "bad_host"},
+				ExcludedIPs:  []string{"10.1.0.0/16", "also_bad"},
+				Unresolvable: tt.unresolvable,
+			}
+			_, err := AllowedIPs(cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("AllowedIPs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAllowedIPs_UnresolvableInventoryHost(t *testing.T) {
+	invPath := writeInventory(t, "hosts", "host1 ansible_host=1.2.3.4\nhost2 ansible_host=bad_host\n")
+	cfg := &models.Config{InventoryPaths: []string{invPath}, Unresolvable: models.Unresolvable{Inventories: models.UnresolvableWarn}}
+	got, err := AllowedIPs(cfg)
+	if err != nil {
+		t.Fatalf("AllowedIPs() error = %v", err)
+	}
+	if want := []string{"1.2.3.4/32"}; !reflect.DeepEqual(prefixStrings(got.AllowedIPs), want) {
+		t.Fatalf("AllowedIPs() = %#v, want %#v", prefixStrings(got.AllowedIPs), want)
+	}
+
+	// the inventory policy overrides the global one, and fails the sync even for optional inventories
+	optional := false
+	cfg = &models.Config{
+		Inventories:  []models.Inventory{{Path: invPath, Required: &optional, Unresolvable: models.UnresolvableFail}},
+		Unresolvable: models.Unresolvable{Inventories: models.UnresolvableIgnore},
+	}
+	if _, err := AllowedIPs(cfg); !errors.Is(err, errUnresolvable) {
+		t.Fatalf("AllowedIPs() error = %v, want unresolvable error", err)
+	}
+}
+
+func TestAllowedIPs_InvalidUnresolvable(t *testing.T) {
+	for _, cfg := range []*models.Config{
+		{Unresolvable: models.Unresolvable{ExcludedIPs: "panic"}},
+		{Inventories: []models.Inventory{{Path: "hosts", Unresolvable: "skip"}}},
+	} {
+		if _, err := AllowedIPs(cfg); err == nil {
+			t.Fatalf("AllowedIPs() expected error for unknown unresolvable policy")
+		}
 	}
 }
 
@@ -34,42 +140,342 @@ func TestAllowedIPs_WithInventoryAndExclusions(t *testing.T) {
 		AllowedIPs:     []string{"10.1.1.1"},
 		ExcludedIPs:    []string{"1.2.3.4"},
 	}
-	got := AllowedIPs(cfg)
-	if len(got) != 2 {
-		t.Fatalf("AllowedIPs() = %#v, want 2 entries", got)
+	got, err := AllowedIPs(cfg)
+	if err != nil {
+		t.Fatalf("AllowedIPs() error = %v", err)
+	}
+	if want := []string{"10.0.0.0/8"}; !reflect.DeepEqual(prefixStrings(got.AllowedIPs), want) {
+		t.Fatalf("AllowedIPs() = %#v, want %#v", got.AllowedIPs, want)
+	}
+	if got.Hosts != 2 {
+		t.Fatalf("AllowedIPs() hosts = %d, want 2", got.Hosts)
+	}
+
+	cfg.ExactAllowedIPs = true
+	got, err = AllowedIPs(cfg)
+	if err != nil {
+		t.Fatalf("AllowedIPs() error = %v", err)
+	}
+	if want := []string{"10.0.0.0/8", "10.1.1.1/32"}; !reflect.DeepEqual(prefixStrings(got.AllowedIPs), want) {
+		t.Fatalf("AllowedIPs() with exact_allowed_ips = %#v, want %#v", got.AllowedIPs, want)
 	}
 }
 
-func TestInventoryIPs_EmptyFile(t *testing.T) {
+func TestInventoryLookups_EmptyFile(t *testing.T) {
 	dir := t.TempDir()
 	invPath := filepath.Join(dir, "hosts")
 	if err := os.WriteFile(invPath, []byte(""), 0o600); err != nil {
 		t.Fatalf("WriteFile() error = %v", err)
 	}
-	got := inventoryIPs(invPath, map[string]bool{})
+	got, err := inventoryLookups(&models.Config{}, models.Inventory{Path: invPath}, &Result{})
+	if err != nil {
+		t.Fatalf("inventoryLookups() error = %v", err)
+	}
 	if got != nil {
-		t.Fatalf("inventoryIPs() = %#v, want nil", got)
+		t.Fatalf("inventoryLookups() = %#v, want nil", got)
 	}
 }
 
-func TestInventoryIPs_MissingFile(t *testing.T) {
-	got := inventoryIPs(filepath.Join(t.TempDir(), "missing"), map[string]bool{})
+func TestInventoryLookups_MissingFile(t *testing.T) {
+	got, err := inventoryLookups(&models.Config{}, models.Inventory{Path: filepath.Join(t.TempDir(), "missing")}, &Result{})
+	if err == nil {
+		t.Fatalf("inventoryLookups() expected error for missing file")
+	}
 	if got != nil {
-		t.Fatalf("inventoryIPs() = %#v, want nil", got)
+		t.Fatalf("inventoryLookups() = %#v, want nil", got)
+	}
+}
+
+func TestAllowedIPs_RequiredInventories(t *testing.T) {
+	dir := t.TempDir()
+	invPath := filepath.Join(dir, "hosts")
+	if err := os.WriteFile(invPath, []byte("host1 ansible_host=1.2.3.4\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	brokenPath := filepath.Join(dir, "broken.yml")
+	if err := os.WriteFile(brokenPath, []byte("all: ["), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	optional, required := false, true
+
+	tests := []struct {
+		name    string
+		cfg     *models.Config
+		wantErr bool
+	}{
+		{name: "optional missing", cfg: &models.Config{InventoryPaths: []string{invPath, filepath.Join(dir, "missing")}}},
+		{name: "optional broken", cfg: &models.Config{InventoryPaths: []string{invPath, brokenPath}}},
+		{name: "optional empty glob", cfg: &models.Config{InventoryPaths: []string{invPath, filepath.Join(dir, "*", "hosts")}}},
+		{name: "global missing", cfg: &models.Config{InventoryPaths: []string{invPath, filepath.Join(dir, "missing")}, InventoriesRequired: true}, wantErr: true},
+		{name: "global broken", cfg: &models.Config{InventoryPaths: []string{invPath, brokenPath}, InventoriesRequired: true}, wantErr: true},
+		{name: "global empty glob", cfg: &models.Config{InventoryPaths: []string{filepath.Join(dir, "*", "hosts")}, InventoriesRequired: true}, wantErr: true},
+		{name: "global invalid glob", cfg: &models.Config{InventoryPaths: []string{filepath.Join(dir, "[")}, InventoriesRequired: true}, wantErr: true},
+		{
+			name:    "source required",
+			cfg:     &models.Config{InventoryPaths: []string{invPath}, Inventories: []models.Inventory{{Path: brokenPath, Required: &required}}},
+			wantErr: true,
+		},
+		{
+			name: "source optional",
+			cfg:  &models.Config{InventoryPaths: []string{invPath}, Inventories: []models.Inventory{{Path: brokenPath, Required: &optional}}, InventoriesRequired: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := AllowedIPs(tt.cfg)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("AllowedIPs() expected error")
+				}
+				if syncErr := Sync(tt.cfg); syncErr == nil {
+					t.Fatalf("Sync() expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("AllowedIPs() error = %v", err)
+			}
+			if !reflect.DeepEqual(prefixStrings(got.AllowedIPs), []string{"1.2.3.4/32"}) {
+				t.Fatalf("AllowedIPs() = %#v", got.AllowedIPs)
+			}
+		})
 	}
 }
 
 func TestHostAllowedIPs_Excluded(t *testing.T) {
-	excluded := map[string]bool{"10.0.0.1/32": true}
-	got := hostAllowedIPs("10.0.0.1", excluded)
+	excluded := parsePrefixes("10.0.0.1/32")
+	got := hostAllowedIPs(parsePrefixes("10.0.0.1/32"), excluded)
 	if len(got) != 0 {
 		t.Fatalf("hostAllowedIPs() = %#v, want empty", got)
 	}
 }
 
-func TestHostAllowedIPs_InvalidHost(t *testing.T) {
-	got := hostAllowedIPs("bad_host", map[string]bool{})
-	if got != nil {
-		t.Fatalf("hostAllowedIPs() = %#v, want nil", got)
+func TestAllowedIPs_Sources(t *testing.T) {
+	invPath := writeInventory(t, "hosts", "web1 ansible_host=10.0.0.0\nweb2 ansible_host=10.0.0.1\n")
+	cfg := &models.Config{InventoryPaths: []string{invPath}, AllowedIPs: []string{"10.0.0.1"}}
+	got, err := AllowedIPs(cfg)
+	if err != nil {
+		t.Fatalf("AllowedIPs() error = %v", err)
+	}
+	if want := []string{"10.0.0.0/31"}; !reflect.DeepEqual(prefixStrings(got.AllowedIPs), want) {
+		t.Fatalf("AllowedIPs() = %#v, want %#v", prefixStrings(got.AllowedIPs), want)
+	}
+	if want := []string{"allowed_ips", "web1", "web2"}; !reflect.DeepEqual(got.Sources.of(got.AllowedIPs[0]), want) {
+		t.Fatalf("AllowedIPs() sources = %#v, want %#v", got.Sources.of(got.AllowedIPs[0]), want)
+	}
+	if src := got.Sources.of(netip.MustParsePrefix("10.0.0.0/32")); !reflect.DeepEqual(src, []string{"web1"}) {
+		t.Fatalf("AllowedIPs() sources of 10.0.0.0/32 = %#v, want web1", src)
+	}
+}
+
+func TestAllowedIPs_MaxAllowedIPs(t *testing.T) {
+	cfg := &models.Config{
+		AllowedIPs:    []string{"10.0.0.1", "10.0.0.2", "10.0.1.1", "10.0.1.2"},
+		ExcludedIPs:   []string{"10.0.0.0"},
+		MaxAllowedIPs: 3,
+	}
+	got, err := AllowedIPs(cfg)
+	if err != nil {
+		t.Fatalf("AllowedIPs() error = %v", err)
+	}
+	if want := []string{"10.0.0.1/32", "10.0.0.2/32", "10.0.1.0/30"}; !reflect.DeepEqual(prefixStrings(got.AllowedIPs), want) {
+		t.Fatalf("AllowedIPs() = %#v, want %#v", prefixStrings(got.AllowedIPs), want)
+	}
+
+	cfg.MaxAllowedIPs = 1
+	if _, err := AllowedIPs(cfg); err == nil {
+		t.Fatalf("AllowedIPs() expected error when max_allowed_ips cannot be met")
+	}
+}
+
+func TestAllowedIPs_MaxAllowedIPsProfileFamilies(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wg0.conf")
+	if err := os.WriteFile(path, []byte("[Interface]\nAddress = 10.10.0.2/32\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	cfg := &models.Config{
+		AllowedIPs:    []string{"10.0.0.1", "10.0.1.1", "fd00::1", "fd00:1::1"},
+		ProfilePath:   path,
+		MaxAllowedIPs: 2,
+	}
+	got, err := AllowedIPs(cfg)
+	if err != nil {
+		t.Fatalf("AllowedIPs() error = %v", err)
+	}
+	// IPv6 CIDRs are not written into an IPv4-only profile, so they do not count towards the limit
+	if want := []string{"10.0.0.1/32", "10.0.1.1/32", "fd00::1/128", "fd00:1::1/128"}; !reflect.DeepEqual(prefixStrings(got.AllowedIPs), want) {
+		t.Fatalf("AllowedIPs() = %#v, want %#v", prefixStrings(got.AllowedIPs), want)
+	}
+
+	cfg.MaxAllowedIPs = 1
+	got, err = AllowedIPs(cfg)
+	if err != nil {
+		t.Fatalf("AllowedIPs() error = %v", err)
+	}
+	if want := []string{"10.0.0.0/23", "fd00::1/128", "fd00:1::1/128"}; !reflect.DeepEqual(prefixStrings(got.AllowedIPs), want) {
+		t.Fatalf("AllowedIPs() = %#v, want %#v", prefixStrings(got.AllowedIPs), want)
+	}
+}
+
+func TestAllowedIPs_NonCanonical(t *testing.T) {
+	cfg := &models.Config{
+		AllowedIPs:  []string{"10.0.0.5/8", "fd00::1/64", "192.168.1.1/24"},
+		ExcludedIPs: []string{"192.168.1.0/24"},
+	}
+	got, err := AllowedIPs(cfg)
+	if err != nil {
+		t.Fatalf("AllowedIPs() error = %v", err)
+	}
+	if want := []string{"10.0.0.0/8", "fd00::/64"}; !reflect.DeepEqual(prefixStrings(got.AllowedIPs), want) {
+		t.Fatalf("AllowedIPs() = %#v, want %#v", prefixStrings(got.AllowedIPs), want)
+	}
+}
+
+func TestHostAllowedIPs_ExcludedRange(t *testing.T) {
+	got := hostAllowedIPs(parsePrefixes("10.0.0.0/30"), parsePrefixes("10.0.0.0/31", "192.168.0.0/16"))
+	if want := []string{"10.0.0.2/31"}; !reflect.DeepEqual(prefixStrings(got), want) {
+		t.Fatalf("hostAllowedIPs() = %#v, want %#v", got, want)
+	}
+}
+
+func TestAllowedIPs_ExcludedRange(t *testing.T) {
+	cfg := &models.Config{
+		AllowedIPs:  []string{"10.0.0.0/8", "fd00::/8"},
+		ExcludedIPs: []string{"10.128.0.0/9", "10.0.0.0/9", "fd00::/9"},
+	}
+	got, err := AllowedIPs(cfg)
+	if err != nil {
+		t.Fatalf("AllowedIPs() error = %v", err)
+	}
+	if want := []string{"fd80::/9"}; !reflect.DeepEqual(prefixStrings(got.AllowedIPs), want) {
+		t.Fatalf("AllowedIPs() = %#v, want %#v", got.AllowedIPs, want)
+	}
+}
+
+func TestAllowedIPs_ExceptMode(t *testing.T) {
+	invPath := writeInventory(t, "hosts", "host1 ansible_host=10.0.0.1\nhost2 ansible_host=128.0.0.0/2\n")
+	tests := []struct {
+		name     string
+		allowed  []string
+		excluded []string
+		want     []string
+	}{
+		{name: "everything by default", excluded: []string{"0.0.0.0/1", "::/1"}, want: []string{"192.0.0.0/2", "8000::/1"}},
+		{name: "allowed ips", allowed: []string{"10.0.0.0/30"}, excluded: []string{"10.0.0.3"}, want: []string{"10.0.0.0/32", "10.0.0.2/32"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &models.Config{
+				Mode:           models.ModeExcept,
+				InventoryPaths: []string{invPath},
+				AllowedIPs:     tt.allowed,
+				ExcludedIPs:    tt.excluded,
+			}
+			got, err := AllowedIPs(cfg)
+			if err != nil {
+				t.Fatalf("AllowedIPs() error = %v", err)
+			}
+			if !reflect.DeepEqual(prefixStrings(got.AllowedIPs), tt.want) {
+				t.Fatalf("AllowedIPs() = %#v, want %#v", got.AllowedIPs, tt.want)
+			}
+			if got.Hosts != 2 {
+				t.Fatalf("AllowedIPs() hosts = %d, want 2", got.Hosts)
+			}
+		})
+	}
+}
+
+func TestAllowedIPs_UnknownMode(t *testing.T) {
+	if _, err := AllowedIPs(&models.Config{Mode: "everything"}); err == nil {
+		t.Fatalf("AllowedIPs() expected error for unknown mode")
+	}
+}
+
+func TestHostAllowedIPs_Unresolved(t *testing.T) {
+	got := hostAllowedIPs(nil, parsePrefixes("10.0.0.1/32"))
+	if len(got) != 0 {
+		t.Fatalf("hostAllowedIPs() = %#v, want empty", got)
+	}
+}
+
+func TestAllowedIPs_InventoryGroupFilters(t *testing.T) {
+	dir := t.TempDir()
+	invPath := filepath.Join(dir, "hosts")
+	contents := "[prod]\nweb1 ansible_host=1.1.1.1\n[eu]\nweb1 ansible_host=1.1.1.1\nweb2 ansible_host=2.2.2.2\n[legacy]\nweb3 ansible_host=3.3.3.3\n"
+	if err := os.WriteFile(invPath, []byte(contents), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	cfg := &models.Config{IncludeGroups: []string{"eu:legacy"}, ExcludeGroups: []string{"prod"}}
+	got := inventoryAllowedIPs(t, cfg, models.Inventory{Path: invPath})
+	want := []string{"2.2.2.2/32", "3.3.3.3/32"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("AllowedIPs() = %#v, want %#v", got, want)
+	}
+}
+
+func TestAllowedIPs_InventoryAddressVars(t *testing.T) {
+	dir := t.TempDir()
+	invPath := filepath.Join(dir, "hosts")
+	contents := "[web]\nweb1 ansible_host=10.0.0.1 public_ipv4=1.1.1.1\nweb2 ansible_host=10.0.0.2 wg_route_cidrs=\"['2.2.2.0/24', '3.3.3.0/24']\"\n[db]\ndb1 ansible_host=10.0.0.3 private_ip=192.168.0.3\n"
+	if err := os.WriteFile(invPath, []byte(contents), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	cfg := &models.Config{
+		AddressVars:      []string{"public_ipv4", "wg_route_cidrs"},
+		GroupAddressVars: map[string][]string{"db": {"private_ip"}},
+	}
+	got := inventoryAllowedIPs(t, cfg, models.Inventory{Path: invPath})
+	want := []string{"1.1.1.1/32", "2.2.2.0/24", "3.3.3.0/24", "192.168.0.3/32"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("AllowedIPs() = %#v, want %#v", got, want)
+	}
+}
+
+func TestAllowedIPs_InventoryVars(t *testing.T) {
+	dir := t.TempDir()
+	invPath := filepath.Join(dir, "hosts")
+	contents := "[web]\nweb1 ansible_host=1.1.1.1 wg_sync_extra_cidrs=\"['10.1.0.0/16']\"\nweb2 ansible_host=2.2.2.2 wg_sync_skip=true\n[legacy]\nold1 ansible_host=3.3.3.3\n[legacy:vars]\nwg_sync_skip=yes\n"
+	if err := os.WriteFile(invPath, []byte(contents), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	got := inventoryAllowedIPs(t, &models.Config{}, models.Inventory{Path: invPath})
+	want := []string{"1.1.1.1/32", "10.1.0.0/16"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("AllowedIPs() = %#v, want %#v", got, want)
+	}
+}
+
+func TestAllowedIPs_TODOHosts(t *testing.T) {
+	dir := t.TempDir()
+	invPath := filepath.Join(dir, "hosts")
+	contents := "host1 ansible_host=1.2.3.4\nhost2 ansible_host=TODO\nhost3 ansible_host=5.6.7.8 ansible_user=todo\n"
+	if err := os.WriteFile(invPath, []byte(contents), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	maxTODOs := 2
+	cfg := &models.Config{InventoryPaths: []string{invPath}, MaxTODOHosts: &maxTODOs}
+	got, err := AllowedIPs(cfg)
+	if err != nil {
+		t.Fatalf("AllowedIPs() error = %v", err)
+	}
+	if got.TODOs != 2 || got.Hosts != 1 {
+		t.Fatalf("AllowedIPs() = %#v, want 1 host and 2 TODOs", got)
+	}
+	if !reflect.DeepEqual(prefixStrings(got.AllowedIPs), []string{"1.2.3.4/32"}) {
+		t.Fatalf("AllowedIPs() = %#v, want only the complete host", got.AllowedIPs)
+	}
+
+	maxTODOs = 1
+	if _, err := AllowedIPs(cfg); err == nil {
+		t.Fatalf("AllowedIPs() expected error when max_todo_hosts is exceeded")
+	}
+	if err := Sync(cfg); err == nil {
+		t.Fatalf("Sync() expected error when max_todo_hosts is exceeded")
 	}
 }
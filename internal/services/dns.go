@@ -0,0 +1,43 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/etkecc/inventory-wg-sync/internal/models"
+	"github.com/etkecc/inventory-wg-sync/internal/utils"
+)
+
+const defaultDNSDeadline = 2 * time.Minute
+
+// newResolver creates the DNS resolver, replaced in tests
+var newResolver = utils.NewResolver
+
+// dnsContext returns the context limiting all DNS lookups of the sync by the dns.deadline
+func dnsContext(cfg *models.Config) (context.Context, context.CancelFunc) {
+	deadline := cfg.DNS.Deadline
+	if deadline <= 0 {
+		deadline = defaultDNSDeadline
+	}
+	return context.WithTimeout(context.Background(), deadline)
+}
+
+// newHostResolver returns the host resolver configured with the dns options
+func newHostResolver(cfg *models.Config) (*utils.HostResolver, error) {
+	resolver, err := newResolver(cfg.DNS.Nameservers, cfg.DNS.Protocol, cfg.DNS.NoSearch)
+	if err != nil {
+		return nil, err
+	}
+	hr := &utils.HostResolver{Resolver: resolver, Workers: cfg.DNS.Workers, Timeout: cfg.DNS.Timeout}
+	if cfg.DNS.StatePath != "" {
+		hr.Cache = utils.LoadDNSCache(cfg.DNS.StatePath, cfg.DNS.Grace)
+	}
+	return hr, nil
+}
+
+// saveDNSState writes the lookup results into the dns.state_path file, if set
+func saveDNSState(hr *utils.HostResolver) {
+	if err := hr.Cache.Save(); err != nil {
+		utils.Log("ERROR: cannot save DNS state:", err)
+	}
+}
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/etkecc/inventory-wg-sync/internal/models"
+	"github.com/etkecc/inventory-wg-sync/internal/utils"
+)
+
+// fakeResolver resolves hosts from the map, without real DNS
+type fakeResolver map[string][]string
+
+func (r fakeResolver) LookupNetIP(ctx context.Context, _, host string) ([]netip.Addr, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if _, ok := r[host]; !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	addrs := make([]netip.Addr, 0, len(r[host]))
+	for _, ip := range r[host] {
+		addrs = append(addrs, netip.MustParseAddr(ip))
+	}
+	return addrs, nil
+}
+
+func (r fakeResolver) LookupCNAME(_ context.Context, host string) (string, error) {
+	return host + ".", nil
+}
+
+func (r fakeResolver) LookupSRV(_ context.Context, _, _, name string) (string, []*net.SRV, error) {
+	return "", nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (r fakeResolver) LookupMX(_ context.Context, name string) ([]*net.MX, error) {
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (r fakeResolver) LookupTXT(_ context.Context, name string) ([]string, error) {
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+// useFakeResolver makes the sync resolve hostnames with the fake resolver during the test
+func useFakeResolver(t *testing.T, hosts map[string][]string) {
+	t.Helper()
+	orig := newResolver
+	newResolver = func(_ []string, _ string, _ bool) (utils.Resolver, error) {
+		return fakeResolver(hosts), nil
+	}
+	t.Cleanup(func() { newResolver = orig })
+}
+
+func TestDNSContext(t *testing.T) {
+	tests := []struct {
+		name     string
+		deadline time.Duration
+		want     time.Duration
+	}{
+		{name: "default", want: defaultDNSDeadline},
+		{name: "configured", deadline: time.Second, want: time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := dnsContext(&models.Config{DNS: models.DNS{Deadline: tt.deadline}})
+			defer cancel()
+			deadline, ok := ctx.Deadline()
+			if !ok || time.Until(deadline) > tt.want || time.Until(deadline) < tt.want-time.Second {
+				t.Fatalf("dnsContext() deadline in %v, want %v", time.Until(deadline), tt.want)
+			}
+		})
+	}
+}
+
+func TestNewHostResolver(t *testing.T) {
+	cfg := &models.Config{DNS: models.DNS{Nameservers: []string{"1.1.1.1"}, Protocol: "tcp", Workers: 1, Timeout: time.Second}}
+	hr, err := newHostResolver(cfg)
+	if err != nil {
+		t.Fatalf("newHostResolver() error = %v", err)
+	}
+	if hr.Resolver == nil || hr.Workers != 1 || hr.Timeout != time.Second {
+		t.Fatalf("newHostResolver() = %#v", hr)
+	}
+
+	cfg.DNS.Protocol = "quic"
+	if _, err := newHostResolver(cfg); err == nil {
+		t.Fatalf("newHostResolver() expected error for unknown protocol")
+	}
+	if _, err := AllowedIPs(cfg); err == nil {
+		t.Fatalf("AllowedIPs() expected error for unknown protocol")
+	}
+}
+
+func TestAllowedIPs_FakeResolver(t *testing.T) {
+	useFakeResolver(t, map[string][]string{"web1.example.com": {"10.0.0.1", "fd00::1"}, "vpn.example.com": {"10.0.0.2"}})
+	invPath := writeInventory(t, "hosts", "web1 ansible_host=web1.example.com\nweb2 ansible_host=missing.example.com\n")
+	cfg := &models.Config{
+		InventoryPaths:  []string{invPath},
+		AllowedIPs:      []string{"vpn.example.com"},
+		ExactAllowedIPs: true,
+	}
+	got, err := AllowedIPs(cfg)
+	if err != nil {
+		t.Fatalf("AllowedIPs() error = %v", err)
+	}
+	if want := []string{"10.0.0.1/32", "10.0.0.2/32", "fd00::1/128"}; !reflect.DeepEqual(prefixStrings(got.AllowedIPs), want) {
+		t.Fatalf("AllowedIPs() = %#v, want %#v", prefixStrings(got.AllowedIPs), want)
+	}
+}
+
+func TestAllowedIPs_DNSState(t *testing.T) {
+	hosts := map[string][]string{"web1.example.com": {"10.0.0.1"}}
+	useFakeResolver(t, hosts)
+	statePath := filepath.Join(t.TempDir(), "dns.json")
+	cfg := &models.Config{AllowedIPs: []string{"web1.example.com"}, DNS: models.DNS{StatePath: statePath}}
+	if _, err := AllowedIPs(cfg); err != nil {
+		t.Fatalf("AllowedIPs() error = %v", err)
+	}
+	if _, err := os.Stat(statePath); err != nil {
+		t.Fatalf("AllowedIPs() did not save the DNS state: %v", err)
+	}
+
+	delete(hosts, "web1.example.com")
+	got, err := AllowedIPs(cfg)
+	if err != nil {
+		t.Fatalf("AllowedIPs() error = %v", err)
+	}
+	if want := []string{"10.0.0.1/32"}; !reflect.DeepEqual(prefixStrings(got.AllowedIPs), want) {
+		t.Fatalf("AllowedIPs() = %#v, want the last known good %#v", prefixStrings(got.AllowedIPs), want)
+	}
+}
+
+func TestAllowedIPs_DNSDeadlineAfterInventories(t *testing.T) {
+	useFakeResolver(t, map[string][]string{"web1.example.com": {"10.0.0.1"}})
+	path := writeScript(t, `sleep 0.3
+echo '{"all": {"hosts": ["web1"]}, "_meta": {"hostvars": {"web1": {"ansible_host": "web1.example.com"}}}}'
+`)
+	// the deadline starts once the inventories are read, so the slow script does not use it up
+	cfg := &models.Config{
+		InventoryPaths: []string{path},
+		DNS:            models.DNS{Deadline: 100 * time.Millisecond},
+		Unresolvable:   models.Unresolvable{Inventories: models.UnresolvableFail},
+	}
+	got, err := AllowedIPs(cfg)
+	if err != nil {
+		t.Fatalf("AllowedIPs() error = %v", err)
+	}
+	if want := []string{"10.0.0.1/32"}; !reflect.DeepEqual(prefixStrings(got.AllowedIPs), want) {
+		t.Fatalf("AllowedIPs() = %#v, want %#v", prefixStrings(got.AllowedIPs), want)
+	}
+}
@@ -0,0 +1,268 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"path"
+	"strings"
+
+	"github.com/etkecc/inventory-wg-sync/internal/models"
+	"github.com/etkecc/inventory-wg-sync/internal/utils"
+)
+
+const (
+	feedAWS   = "aws"   // AWS ip-ranges.json
+	feedGCP   = "gcp"   // Google Cloud cloud.json (or goog.json)
+	feedAzure = "azure" // Azure service tags (ServiceTags_Public_*.json)
+	feedPlain = "plain" // one IP or CIDR per line, # comments
+)
+
+// feedRange is an IP range of the feed with the services and the region it belongs to
+type feedRange struct {
+	prefix   string
+	services []string
+	region   string
+}
+
+// feedDocument is the top level of the JSON feeds, used to detect their format
+type feedDocument struct {
+	Prefixes     []json.RawMessage `json:"prefixes"`
+	IPv6Prefixes []json.RawMessage `json:"ipv6_prefixes"`
+	Values       json.RawMessage   `json:"values"`
+}
+
+// awsFeed is the AWS ip-ranges.json document
+type awsFeed struct {
+	Prefixes []struct {
+		IPPrefix string `json:"ip_prefix"`
+		Region   string `json:"region"`
+		Service  string `json:"service"`
+	} `json:"prefixes"`
+	IPv6Prefixes []struct {
+		IPv6Prefix string `json:"ipv6_prefix"`
+		Region     string `json:"region"`
+		Service    string `json:"service"`
+	} `json:"ipv6_prefixes"`
+}
+
+// gcpFeed is the Google Cloud cloud.json document, the region is called scope there
+type gcpFeed struct {
+	Prefixes []struct {
+		IPv4Prefix string `json:"ipv4Prefix"`
+		IPv6Prefix string `json:"ipv6Prefix"`
+		Service    string `json:"service"`
+		Scope      string `json:"scope"`
+	} `json:"prefixes"`
+}
+
+// azureFeed is the Azure service tags document, the service is either the tag name (e.g. "Storage.WestEurope") or its system service
+type azureFeed struct {
+	Values []struct {
+		Name       string `json:"name"`
+		Properties struct {
+			Region          string   `json:"region"`
+			SystemService   string   `json:"systemService"`
+			AddressPrefixes []string `json:"addressPrefixes"`
+		} `json:"properties"`
+	} `json:"values"`
+}
+
+// feedsIPs returns allowed IPs (with excluded IPs carved out) of all feeds.
+// Errors are returned for required feeds only, and logged for the optional ones
+func feedsIPs(cfg *models.Config, excludedIPs []netip.Prefix, res *Result) ([]netip.Prefix, error) {
+	allowed := []netip.Prefix{}
+	for _, feed := range cfg.Feeds {
+		ips, err := feedIPs(cfg, feed)
+		if err != nil {
+			if feed.Required {
+				return nil, fmt.Errorf("cannot read required feed %s: %w", feed.Source, err)
+			}
+			utils.Log("ERROR: cannot read feed", feed.Source, ":", err)
+			continue
+		}
+		ips = utils.SubtractCIDRs(ips, excludedIPs)
+		res.Sources.add("feed "+feed.Source, ips...)
+		allowed = append(allowed, ips...)
+	}
+	return allowed, nil
+}
+
+// feedIPs reads the feed and returns its ranges matching the services and regions filters
+func feedIPs(cfg *models.Config, feed models.Feed) ([]netip.Prefix, error) {
+	contents, err := readSource(cfg, feed.Source)
+	if err != nil {
+		return nil, err
+	}
+	format := feed.Format
+	if format == "" {
+		if format, err = detectFeedFormat(contents); err != nil {
+			return nil, err
+		}
+	}
+	utils.Debug("feed", feed.Source, "format is", format)
+	ranges, err := parseFeed(format, contents)
+	if err != nil {
+		return nil, err
+	}
+	if format == feedPlain && (len(feed.Services) > 0 || len(feed.Regions) > 0) {
+		utils.Log("WARNING: feed", feed.Source, "is a plain list without services and regions, ignoring its filters")
+		feed.Services, feed.Regions = nil, nil
+	}
+
+	ips := []netip.Prefix{}
+	for _, r := range ranges {
+		if !r.matches(feed) {
+			continue
+		}
+		prefix, err := feedPrefix(r.prefix)
+		if err != nil {
+			utils.Log("WARNING: feed", feed.Source, "has invalid range", r.prefix, ", skipping")
+			continue
+		}
+		ips = append(ips, utils.CanonicalPrefix(prefix, "feed "+feed.Source+" range "+r.prefix))
+	}
+	utils.Debug("feed", feed.Source, "has", len(ips), "matching ranges out of", len(ranges))
+	return ips, nil
+}
+
+// detectFeedFormat detects the feed format by the fields of its prefix items, because AWS and Google Cloud documents
+// share the top-level keys (prefixes, syncToken, creationTime). Non-JSON feeds are plain lists
+func detectFeedFormat(contents []byte) (string, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(contents), []byte("{")) {
+		return feedPlain, nil
+	}
+	var doc feedDocument
+	if err := json.Unmarshal(contents, &doc); err != nil {
+		return "", fmt.Errorf("cannot parse feed: %w", err)
+	}
+	if doc.Values != nil {
+		return feedAzure, nil
+	}
+	for _, item := range append(doc.Prefixes, doc.IPv6Prefixes...) {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(item, &fields); err != nil {
+			return "", fmt.Errorf("cannot parse feed prefix: %w", err)
+		}
+		switch {
+		case fields["ip_prefix"] != nil || fields["ipv6_prefix"] != nil:
+			return feedAWS, nil
+		case fields["ipv4Prefix"] != nil || fields["ipv6Prefix"] != nil:
+			return feedGCP, nil
+		}
+	}
+	return "", errors.New("cannot detect feed format, set it explicitly")
+}
+
+// parseFeed parses the feed document of the format into ranges
+func parseFeed(format string, contents []byte) ([]feedRange, error) {
+	switch format {
+	case feedAWS:
+		return parseAWSFeed(contents)
+	case feedGCP:
+		return parseGCPFeed(contents)
+	case feedAzure:
+		return parseAzureFeed(contents)
+	case feedPlain:
+		return parsePlainFeed(contents)
+	default:
+		return nil, fmt.Errorf("unknown feed format %q, must be %s, %s, %s, or %s", format, feedAWS, feedGCP, feedAzure, feedPlain)
+	}
+}
+
+func parseAWSFeed(contents []byte) ([]feedRange, error) {
+	var doc awsFeed
+	if err := json.Unmarshal(contents, &doc); err != nil {
+		return nil, fmt.Errorf("cannot parse AWS feed: %w", err)
+	}
+	ranges := make([]feedRange, 0, len(doc.Prefixes)+len(doc.IPv6Prefixes))
+	for _, p := range doc.Prefixes {
+		ranges = append(ranges, feedRange{prefix: p.IPPrefix, services: []string{p.Service}, region: p.Region})
+	}
+	for _, p := range doc.IPv6Prefixes {
+		ranges = append(ranges, feedRange{prefix: p.IPv6Prefix, services: []string{p.Service}, region: p.Region})
+	}
+	return ranges, nil
+}
+
+func parseGCPFeed(contents []byte) ([]feedRange, error) {
+	var doc gcpFeed
+	if err := json.Unmarshal(contents, &doc); err != nil {
+		return nil, fmt.Errorf("cannot parse GCP feed: %w", err)
+	}
+	ranges := make([]feedRange, 0, len(doc.Prefixes))
+	for _, p := range doc.Prefixes {
+		prefix := p.IPv4Prefix
+		if prefix == "" {
+			prefix = p.IPv6Prefix
+		}
+		ranges = append(ranges, feedRange{prefix: prefix, services: []string{p.Service}, region: p.Scope})
+	}
+	return ranges, nil
+}
+
+func parseAzureFeed(contents []byte) ([]feedRange, error) {
+	var doc azureFeed
+	if err := json.Unmarshal(contents, &doc); err != nil {
+		return nil, fmt.Errorf("cannot parse Azure feed: %w", err)
+	}
+	ranges := []feedRange{}
+	for _, tag := range doc.Values {
+		services := []string{tag.Name}
+		if tag.Properties.SystemService != "" {
+			services = append(services, tag.Properties.SystemService)
+		}
+		for _, prefix := range tag.Properties.AddressPrefixes {
+			ranges = append(ranges, feedRange{prefix: prefix, services: services, region: tag.Properties.Region})
+		}
+	}
+	return ranges, nil
+}
+
+func parsePlainFeed(contents []byte) ([]feedRange, error) {
+	ranges := []feedRange{}
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if line = strings.TrimSpace(line); line != "" {
+			ranges = append(ranges, feedRange{prefix: line})
+		}
+	}
+	return ranges, scanner.Err()
+}
+
+// matches tells if the range passes the services and regions filters of the feed
+func (r feedRange) matches(feed models.Feed) bool {
+	if len(feed.Services) > 0 && !matchFeedPatterns(feed.Services, r.services...) {
+		return false
+	}
+	return len(feed.Regions) == 0 || matchFeedPatterns(feed.Regions, r.region)
+}
+
+// matchFeedPatterns tells if any of the values matches any of the case-insensitive glob patterns
+func matchFeedPatterns(patterns []string, values ...string) bool {
+	for _, pattern := range patterns {
+		for _, value := range values {
+			if ok, err := path.Match(strings.ToLower(pattern), strings.ToLower(value)); err == nil && ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// feedPrefix parses the range of the feed, which is a CIDR or a single IP
+func feedPrefix(value string) (netip.Prefix, error) {
+	if prefix, err := netip.ParsePrefix(value); err == nil {
+		return prefix, nil
+	}
+	ip, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	ip = ip.Unmap()
+	return netip.PrefixFrom(ip, ip.BitLen()), nil
+}
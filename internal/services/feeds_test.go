@@ -0,0 +1,166 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/etkecc/inventory-wg-sync/internal/models"
+)
+
+const (
+	testAWSFeed = `{
+  "syncToken": "1700000000",
+  "prefixes": [
+    {"ip_prefix": "3.5.140.0/22", "region": "ap-northeast-2", "service": "AMAZON"},
+    {"ip_prefix": "13.32.0.0/15", "region": "GLOBAL", "service": "CLOUDFRONT"},
+    {"ip_prefix": "52.94.76.0/22", "region": "eu-west-1", "service": "EC2"}
+  ],
+  "ipv6_prefixes": [
+    {"ipv6_prefix": "2600:9000::/28", "region": "GLOBAL", "service": "CLOUDFRONT"}
+  ]
+}`
+	testGCPFeed = `{
+  "syncToken": "1700000000000",
+  "creationTime": "2023-11-14T22:13:20.000000",
+  "prefixes": [
+    {"ipv4Prefix": "34.80.0.0/15", "service": "Google Cloud", "scope": "asia-east1"},
+    {"ipv4Prefix": "34.140.0.0/16", "service": "Google Cloud", "scope": "europe-west1"},
+    {"ipv6Prefix": "2600:1900:4010::/44", "service": "Google Cloud", "scope": "europe-west1"}
+  ]
+}`
+	testAzureFeed = `{
+  "changeNumber": 1,
+  "values": [
+    {"name": "Storage.WestEurope", "properties": {"region": "westeurope", "systemService": "AzureStorage", "addressPrefixes": ["20.38.108.0/23", "2603:1020:206::/48"]}},
+    {"name": "AzureFrontDoor.Frontend", "properties": {"region": "", "systemService": "AzureFrontDoor", "addressPrefixes": ["13.107.208.0/24"]}}
+  ]
+}`
+)
+
+func TestFeedIPs(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"aws.json":   testAWSFeed,
+		"gcp.json":   testGCPFeed,
+		"azure.json": testAzureFeed,
+		"plain.txt":  "# vendor ranges\n192.0.2.0/24\n198.51.100.7 # single host\n\nnot-a-cidr\n",
+	})
+	tests := []struct {
+		name string
+		feed models.Feed
+		want []string
+	}{
+		{name: "aws service", feed: models.Feed{Source: "aws.json", Services: []string{"cloudfront"}}, want: []string{"13.32.0.0/15", "2600:9000::/28"}},
+		{name: "aws region", feed: models.Feed{Source: "aws.json", Format: feedAWS, Regions: []string{"eu-*", "ap-*"}}, want: []string{"3.5.140.0/22", "52.94.76.0/22"}},
+		{name: "gcp region", feed: models.Feed{Source: "gcp.json", Regions: []string{"europe-west1"}}, want: []string{"34.140.0.0/16", "2600:1900:4010::/44"}},
+		{name: "azure tag name", feed: models.Feed{Source: "azure.json", Services: []string{"Storage.*"}}, want: []string{"20.38.108.0/23", "2603:1020:206::/48"}},
+		{name: "azure system service", feed: models.Feed{Source: "azure.json", Services: []string{"AzureFrontDoor"}}, want: []string{"13.107.208.0/24"}},
+		{name: "azure region", feed: models.Feed{Source: "azure.json", Regions: []string{"westeurope"}}, want: []string{"20.38.108.0/23", "2603:1020:206::/48"}},
+		{name: "plain", feed: models.Feed{Source: "plain.txt", Services: []string{"ignored"}}, want: []string{"192.0.2.0/24", "198.51.100.7/32"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.feed.Source = filepath.Join(dir, tt.feed.Source)
+			got, err := feedIPs(&models.Config{}, tt.feed)
+			if err != nil {
+				t.Fatalf("feedIPs() error = %v", err)
+			}
+			if !reflect.DeepEqual(prefixStrings(got), tt.want) {
+				t.Fatalf("feedIPs() = %#v, want %#v", prefixStrings(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestFeedIPs_Errors(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"aws.json": testAWSFeed, "broken.json": `{"prefixes": [1]}`})
+	for _, feed := range []models.Feed{
+		{Source: filepath.Join(dir, "missing.json")},
+		{Source: filepath.Join(dir, "aws.json"), Format: "oracle"},
+		{Source: filepath.Join(dir, "broken.json")},
+	} {
+		if _, err := feedIPs(&models.Config{}, feed); err == nil {
+			t.Fatalf("feedIPs(%#v) expected error", feed)
+		}
+	}
+}
+
+func TestDetectFeedFormat(t *testing.T) {
+	tests := []struct {
+		contents string
+		want     string
+		wantErr  bool
+	}{
+		{contents: testAWSFeed, want: feedAWS},
+		{contents: `{"syncToken": "1", "prefixes": [], "ipv6_prefixes": [{"ipv6_prefix": "2600:9000::/28"}]}`, want: feedAWS},
+		{contents: testGCPFeed, want: feedGCP},
+		{contents: testAzureFeed, want: feedAzure},
+		{contents: "# vendor ranges\n192.0.2.0/24\n", want: feedPlain},
+		{contents: `{"syncToken": "1", "prefixes": []}`, wantErr: true},
+		{contents: `{"prefixes": [1]}`, wantErr: true},
+		{contents: `{"prefixes": `, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := detectFeedFormat([]byte(tt.contents))
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("detectFeedFormat(%q) error = %v, wantErr %v", tt.contents, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Fatalf("detectFeedFormat(%q) = %q, want %q", tt.contents, got, tt.want)
+		}
+	}
+}
+
+func TestFeedIPs_URL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ip-ranges.json" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(testAWSFeed)) //nolint:errcheck // test server
+	}))
+	defer server.Close()
+
+	got, err := feedIPs(&models.Config{}, models.Feed{Source: server.URL + "/ip-ranges.json", Services: []string{"EC2"}})
+	if err != nil {
+		t.Fatalf("feedIPs() error = %v", err)
+	}
+	if want := []string{"52.94.76.0/22"}; !reflect.DeepEqual(prefixStrings(got), want) {
+		t.Fatalf("feedIPs() = %#v, want %#v", prefixStrings(got), want)
+	}
+	if _, err := feedIPs(&models.Config{}, models.Feed{Source: server.URL + "/missing.json"}); err == nil {
+		t.Fatalf("feedIPs() expected error for HTTP 404")
+	}
+}
+
+func TestAllowedIPs_Feeds(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"plain.txt": "10.0.0.0/8\n"})
+	cfg := &models.Config{
+		ExcludedIPs: []string{"10.0.0.0/9"},
+		Feeds: []models.Feed{
+			{Source: filepath.Join(dir, "plain.txt")},
+			{Source: filepath.Join(dir, "missing.json")},
+		},
+	}
+	got, err := AllowedIPs(cfg)
+	if err != nil {
+		t.Fatalf("AllowedIPs() error = %v", err)
+	}
+	if want := []string{"10.128.0.0/9"}; !reflect.DeepEqual(prefixStrings(got.AllowedIPs), want) {
+		t.Fatalf("AllowedIPs() = %#v, want %#v", prefixStrings(got.AllowedIPs), want)
+	}
+	if want := []string{"feed " + cfg.Feeds[0].Source}; !reflect.DeepEqual(got.Sources.of(got.AllowedIPs[0]), want) {
+		t.Fatalf("AllowedIPs() sources = %#v, want %#v", got.Sources.of(got.AllowedIPs[0]), want)
+	}
+
+	cfg.Feeds[1].Required = true
+	if _, err := AllowedIPs(cfg); err == nil {
+		t.Fatalf("AllowedIPs() expected error for missing required feed")
+	}
+}
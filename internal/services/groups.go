@@ -0,0 +1,85 @@
+package services
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/etkecc/go-ansible"
+
+	"github.com/etkecc/inventory-wg-sync/internal/models"
+)
+
+// hostSelected tells if the host passes the include_groups and exclude_groups filters
+func hostSelected(cfg *models.Config, host *ansible.Host) bool {
+	if len(cfg.IncludeGroups) > 0 && !matchGroups(cfg.IncludeGroups, host.Groups) {
+		return false
+	}
+	return !matchGroups(cfg.ExcludeGroups, host.Groups)
+}
+
+// matchGroups tells if the groups match any of the Ansible-style patterns
+func matchGroups(patterns, groups []string) bool {
+	for _, pattern := range patterns {
+		if matchGroupPattern(pattern, groups) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGroupPattern matches the groups against the Ansible-style pattern, e.g. "prod:&eu:!legacy"
+// means "hosts of the prod group, that are also in the eu group, but not in the legacy group".
+// Same as Ansible, a pattern that starts with an intersection or exclusion is applied to all hosts
+func matchGroupPattern(pattern string, groups []string) bool {
+	terms := strings.FieldsFunc(pattern, func(r rune) bool { return r == ':' || r == ',' })
+	if len(terms) == 0 {
+		return false
+	}
+
+	var included, hasUnions bool
+	for _, term := range terms {
+		term = strings.TrimSpace(term)
+		switch {
+		case strings.HasPrefix(term, "!"):
+			if containsGroup(groups, term[1:]) {
+				return false
+			}
+		case strings.HasPrefix(term, "&"):
+			if !containsGroup(groups, term[1:]) {
+				return false
+			}
+		default:
+			hasUnions = true
+			included = included || containsGroup(groups, term)
+		}
+	}
+	return included || !hasUnions
+}
+
+// containsGroup tells if any of the groups matches the name, which may be a glob ("prod-*") or a regex ("~^prod")
+func containsGroup(groups []string, name string) bool {
+	if name == groupAll || name == "*" {
+		return true
+	}
+	var re *regexp.Regexp
+	if strings.HasPrefix(name, "~") {
+		var err error
+		if re, err = regexp.Compile(name[1:]); err != nil {
+			return false
+		}
+	}
+
+	for _, group := range groups {
+		if re != nil {
+			if re.MatchString(group) {
+				return true
+			}
+			continue
+		}
+		if matched, err := path.Match(name, group); group == name || (err == nil && matched) {
+			return true
+		}
+	}
+	return false
+}
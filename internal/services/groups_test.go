@@ -0,0 +1,71 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/etkecc/go-ansible"
+
+	"github.com/etkecc/inventory-wg-sync/internal/models"
+)
+
+func TestMatchGroupPattern(t *testing.T) {
+	groups := []string{"all", "prod", "eu", "web-1"}
+	tests := []struct {
+		pattern string
+		want    bool
+	}{
+		{pattern: "prod", want: true},
+		{pattern: "staging", want: false},
+		{pattern: "staging:prod", want: true},
+		{pattern: "staging,prod", want: true},
+		{pattern: "prod:&eu", want: true},
+		{pattern: "prod:&us", want: false},
+		{pattern: "prod:&eu:!legacy", want: true},
+		{pattern: "prod:&eu:!web-*", want: false},
+		{pattern: "!legacy", want: true},
+		{pattern: "!eu", want: false},
+		{pattern: "&eu", want: true},
+		{pattern: "all:!prod", want: false},
+		{pattern: "*", want: true},
+		{pattern: "~^web-\\d$", want: true},
+		{pattern: "~[", want: false},
+		{pattern: "web-[", want: false},
+		{pattern: "", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			if got := matchGroupPattern(tt.pattern, groups); got != tt.want {
+				t.Fatalf("matchGroupPattern(%q) = %v, want %v", tt.pattern, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostSelected(t *testing.T) {
+	cfg := &models.Config{
+		IncludeGroups: []string{"prod:&eu", "staging"},
+		ExcludeGroups: []string{"legacy"},
+	}
+	tests := []struct {
+		name   string
+		groups []string
+		want   bool
+	}{
+		{name: "prod eu", groups: []string{"prod", "eu"}, want: true},
+		{name: "prod us", groups: []string{"prod", "us"}, want: false},
+		{name: "staging", groups: []string{"staging"}, want: true},
+		{name: "legacy", groups: []string{"staging", "legacy"}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hostSelected(cfg, &ansible.Host{Groups: tt.groups}); got != tt.want {
+				t.Fatalf("hostSelected(%v) = %v, want %v", tt.groups, got, tt.want)
+			}
+		})
+	}
+
+	if !hostSelected(&models.Config{}, &ansible.Host{}) {
+		t.Fatalf("hostSelected() without filters should select all hosts")
+	}
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+
+	"github.com/etkecc/go-kit"
+
+	"github.com/etkecc/inventory-wg-sync/internal/models"
+	"github.com/etkecc/inventory-wg-sync/internal/utils"
+)
+
+// maxReportedRemovals limits the number of removed CIDRs listed in the log
+const maxReportedRemovals = 50
+
+// checkShrinkage refuses to apply the new AllowedIPs if too many of the current ones would be removed
+func checkShrinkage(cfg *models.Config, current, next []netip.Prefix) error {
+	if cfg.Force || len(current) == 0 || (cfg.MaxRemoved <= 0 && cfg.MaxRemovedPercent <= 0) {
+		return nil
+	}
+
+	removed := uncoveredCIDRs(current, next)
+	percent := float64(len(removed)) / float64(len(current)) * 100
+	var reason string
+	switch {
+	case cfg.MaxRemoved > 0 && len(removed) > cfg.MaxRemoved:
+		reason = fmt.Sprintf("more than max_removed (%d)", cfg.MaxRemoved)
+	case cfg.MaxRemovedPercent > 0 && percent > cfg.MaxRemovedPercent:
+		reason = fmt.Sprintf("more than max_removed_percent (%.1f%%)", cfg.MaxRemovedPercent)
+	default:
+		return nil
+	}
+
+	reportRemovals(removed)
+	return fmt.Errorf("refusing to update the profile: %d of %d AllowedIPs (%.1f%%) would be removed, %s; run with --force to apply anyway", len(removed), len(current), percent, reason)
+}
+
+// reportRemovals logs the CIDRs that would be removed from the profile
+func reportRemovals(removed []netip.Prefix) {
+	utils.Log("AllowedIPs that would be removed:")
+	for i, cidr := range removed {
+		if i == maxReportedRemovals {
+			utils.Log("  ... and", len(removed)-maxReportedRemovals, "more")
+			break
+		}
+		utils.Log("  -", cidr)
+	}
+}
+
+// uncoveredCIDRs returns the current CIDRs that are not covered by any of the next CIDRs,
+// so a CIDR merged into a broader one is not considered removed
+func uncoveredCIDRs(current, next []netip.Prefix) []netip.Prefix {
+	removed := []netip.Prefix{}
+	for _, prefix := range kit.RemoveFromSlice(current, next) {
+		covered := slices.ContainsFunc(next, func(p netip.Prefix) bool {
+			return p.Bits() <= prefix.Bits() && p.Contains(prefix.Addr())
+		})
+		if !covered {
+			removed = append(removed, prefix)
+		}
+	}
+	return removed
+}
+
+// currentAllowedIPs returns the CIDRs of all AllowedIPs lines of the profile
+func currentAllowedIPs(lines []string) []netip.Prefix {
+	current := []netip.Prefix{}
+	seen := map[netip.Prefix]bool{}
+	for _, cidr := range profileValues(lines, "AllowedIPs") {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			utils.Debug("profile AllowedIPs entry", cidr, "is not a CIDR")
+			continue
+		}
+		if prefix = prefix.Masked(); !seen[prefix] {
+			seen[prefix] = true
+			current = append(current, prefix)
+		}
+	}
+	return current
+}
@@ -0,0 +1,59 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/etkecc/inventory-wg-sync/internal/models"
+)
+
+func TestCheckShrinkage(t *testing.T) {
+	current := []string{"10.0.0.1/32", "10.0.0.2/32", "10.0.0.3/32", "10.0.0.4/32"}
+	tests := []struct {
+		name    string
+		cfg     *models.Config
+		next    []string
+		wantErr bool
+	}{
+		{name: "no limits", cfg: &models.Config{}, next: []string{}},
+		{name: "within max_removed", cfg: &models.Config{MaxRemoved: 2}, next: []string{"10.0.0.1/32", "10.0.0.2/32"}},
+		{name: "over max_removed", cfg: &models.Config{MaxRemoved: 2}, next: []string{"10.0.0.1/32"}, wantErr: true},
+		{name: "within max_removed_percent", cfg: &models.Config{MaxRemovedPercent: 25}, next: []string{"10.0.0.1/32", "10.0.0.2/32", "10.0.0.3/32", "10.0.0.9/32"}},
+		{name: "over max_removed_percent", cfg: &models.Config{MaxRemovedPercent: 25}, next: []string{"10.0.0.1/32", "10.0.0.2/32"}, wantErr: true},
+		{name: "forced", cfg: &models.Config{MaxRemoved: 1, Force: true}, next: []string{}},
+		{name: "merged", cfg: &models.Config{MaxRemoved: 1}, next: []string{"10.0.0.0/29"}},
+		{name: "additions only", cfg: &models.Config{MaxRemoved: 1}, next: append([]string{"10.0.0.5/32"}, current...)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkShrinkage(tt.cfg, parsePrefixes(current...), parsePrefixes(tt.next...))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkShrinkage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckShrinkage_EmptyProfile(t *testing.T) {
+	if err := checkShrinkage(&models.Config{MaxRemoved: 1}, nil, parsePrefixes("10.0.0.1/32")); err != nil {
+		t.Fatalf("checkShrinkage() error = %v", err)
+	}
+}
+
+func TestCurrentAllowedIPs(t *testing.T) {
+	lines := []string{
+		"[Interface]",
+		"Address = 10.0.0.1/32",
+		"[Peer]",
+		"AllowedIPs = 10.0.0.1/32, fd00::1/128",
+		"[Peer]",
+		"AllowedIPs=10.0.0.2/32,10.0.0.1/32",
+		"AllowedIPs = 10.0.0.3/32, invalid",
+		"AllowedIPs = ",
+	}
+	want := []string{"10.0.0.1/32", "fd00::1/128", "10.0.0.2/32", "10.0.0.3/32"}
+	if got := prefixStrings(currentAllowedIPs(lines)); !reflect.DeepEqual(got, want) {
+		t.Fatalf("currentAllowedIPs() = %#v, want %#v", got, want)
+	}
+}
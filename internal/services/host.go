@@ -0,0 +1,99 @@
+package services
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/etkecc/go-ansible"
+
+	"github.com/etkecc/inventory-wg-sync/internal/models"
+	"github.com/etkecc/inventory-wg-sync/internal/utils"
+)
+
+const (
+	skipVar       = "wg_sync_skip"        // host/group var to ignore the host
+	extraCIDRsVar = "wg_sync_extra_cidrs" // host/group var with additional entries to route
+)
+
+// usableHostAddresses applies the host filters and returns the host's addresses, updating the result's counters
+func usableHostAddresses(cfg *models.Config, host *ansible.Host, res *Result) []string {
+	if !hostSelected(cfg, host) {
+		utils.Debug("host", host.Name, "is filtered out by groups", host.Groups)
+		res.Skipped++
+		return nil
+	}
+	if hostSkipped(host) {
+		utils.Debug("host", host.Name, "is skipped via", skipVar)
+		res.Skipped++
+		return nil
+	}
+	if host.HasTODOs() {
+		utils.Log("WARNING: host", host.Name, "has TODO placeholders, skipping")
+		res.TODOs++
+		return nil
+	}
+
+	addresses := hostAddresses(cfg, host)
+	if len(addresses) == 0 {
+		utils.Debug("host", host.Name, "has no address")
+		return nil
+	}
+	res.Hosts++
+	return addresses
+}
+
+// hostSkipped tells if the host opted out via the wg_sync_skip var
+func hostSkipped(host *ansible.Host) bool {
+	return host.Vars.Yes(false, skipVar)
+}
+
+// hostAddresses returns the host's entries (IPs, CIDRs, hostnames) to route,
+// taken from the first of the address vars that is set (falling back to ansible_host), and the wg_sync_extra_cidrs var
+func hostAddresses(cfg *models.Config, host *ansible.Host) []string {
+	extra := varStrings(host.Vars[extraCIDRsVar])
+	for _, key := range addressVars(cfg, host) {
+		if values := varStrings(host.Vars[key]); len(values) > 0 {
+			return append(values, extra...)
+		}
+	}
+	if host.Host == "" {
+		return extra
+	}
+	return append([]string{host.Host}, extra...)
+}
+
+// addressVars returns the group override for the host's main group or any other host group (in alphabetical order),
+// or the global address vars
+func addressVars(cfg *models.Config, host *ansible.Host) []string {
+	if keys, ok := cfg.GroupAddressVars[host.Group]; ok {
+		return keys
+	}
+	for _, group := range slices.Sorted(slices.Values(host.Groups)) {
+		if keys, ok := cfg.GroupAddressVars[group]; ok {
+			return keys
+		}
+	}
+	return cfg.AddressVars
+}
+
+// varStrings converts scalar or list var into list of strings, comma-separated strings are split
+func varStrings(v any) []string {
+	var values []string
+	switch value := v.(type) {
+	case []any:
+		for _, item := range value {
+			values = append(values, varStrings(item)...)
+		}
+	case []string:
+		for _, item := range value {
+			values = append(values, varStrings(item)...)
+		}
+	default:
+		for _, item := range strings.Split(varString(v), ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+	}
+	return values
+}
@@ -0,0 +1,107 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/etkecc/go-ansible"
+
+	"github.com/etkecc/inventory-wg-sync/internal/models"
+)
+
+func TestHostAddresses(t *testing.T) {
+	cfg := &models.Config{
+		AddressVars: []string{"public_ipv4", "wg_route_cidrs"},
+		GroupAddressVars: map[string][]string{
+			"bastioned": {"bastion_ip"},
+			"internal":  {"private_ip"},
+		},
+	}
+	tests := []struct {
+		name string
+		host *ansible.Host
+		want []string
+	}{
+		{
+			name: "first var",
+			host: &ansible.Host{Host: "1.1.1.1", Vars: ansible.HostVars{"public_ipv4": "2.2.2.2", "wg_route_cidrs": []any{"3.3.3.0/24"}}},
+			want: []string{"2.2.2.2"},
+		},
+		{
+			name: "list var",
+			host: &ansible.Host{Host: "1.1.1.1", Vars: ansible.HostVars{"wg_route_cidrs": []any{"3.3.3.0/24", "4.4.4.0/24", 5}}},
+			want: []string{"3.3.3.0/24", "4.4.4.0/24", "5"},
+		},
+		{
+			name: "comma-separated var",
+			host: &ansible.Host{Host: "1.1.1.1", Vars: ansible.HostVars{"public_ipv4": "2.2.2.2, 3.3.3.3"}},
+			want: []string{"2.2.2.2", "3.3.3.3"},
+		},
+		{
+			name: "fallback to ansible_host",
+			host: &ansible.Host{Host: "1.1.1.1", Vars: ansible.HostVars{"public_ipv4": ""}},
+			want: []string{"1.1.1.1"},
+		},
+		{
+			name: "main group override",
+			host: &ansible.Host{Host: "1.1.1.1", Group: "internal", Groups: []string{"bastioned", "internal"}, Vars: ansible.HostVars{"private_ip": "10.0.0.1", "bastion_ip": "2.2.2.2"}},
+			want: []string{"10.0.0.1"},
+		},
+		{
+			name: "other group override",
+			host: &ansible.Host{Host: "1.1.1.1", Group: "web", Groups: []string{"web", "internal", "bastioned"}, Vars: ansible.HostVars{"private_ip": "10.0.0.1", "bastion_ip": "2.2.2.2"}},
+			want: []string{"2.2.2.2"},
+		},
+		{
+			name: "extra cidrs",
+			host: &ansible.Host{Host: "1.1.1.1", Vars: ansible.HostVars{extraCIDRsVar: []any{"10.0.0.0/8"}}},
+			want: []string{"1.1.1.1", "10.0.0.0/8"},
+		},
+		{
+			name: "extra cidrs with address var",
+			host: &ansible.Host{Vars: ansible.HostVars{"public_ipv4": "2.2.2.2", extraCIDRsVar: "10.0.0.0/8"}},
+			want: []string{"2.2.2.2", "10.0.0.0/8"},
+		},
+		{
+			name: "extra cidrs only",
+			host: &ansible.Host{Vars: ansible.HostVars{extraCIDRsVar: "10.0.0.0/8"}},
+			want: []string{"10.0.0.0/8"},
+		},
+		{
+			name: "no address",
+			host: &ansible.Host{},
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hostAddresses(cfg, tt.host); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("hostAddresses() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostSkipped(t *testing.T) {
+	tests := []struct {
+		value any
+		want  bool
+	}{
+		{value: true, want: true},
+		{value: "yes", want: true},
+		{value: "True", want: true},
+		{value: false, want: false},
+		{value: "no", want: false},
+		{value: nil, want: false},
+	}
+	for _, tt := range tests {
+		host := &ansible.Host{Vars: ansible.HostVars{}}
+		if tt.value != nil {
+			host.Vars[skipVar] = tt.value
+		}
+		if got := hostSkipped(host); got != tt.want {
+			t.Fatalf("hostSkipped(%#v) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
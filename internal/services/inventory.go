@@ -0,0 +1,434 @@
+package services
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/etkecc/go-ansible"
+	"gopkg.in/yaml.v3"
+
+	"github.com/etkecc/inventory-wg-sync/internal/models"
+	"github.com/etkecc/inventory-wg-sync/internal/utils"
+)
+
+const (
+	formatINI  = "ini"
+	formatYAML = "yaml"
+
+	groupAll       = "all"
+	groupUngrouped = "ungrouped"
+)
+
+// yamlKeyRegex matches a YAML mapping key without a value, e.g. "all:"
+var yamlKeyRegex = regexp.MustCompile(`^[^\s=\[]+:\s*(#.*)?$`)
+
+// yamlGroup is a group of the YAML inventory
+type yamlGroup struct {
+	Hosts    map[string]map[string]any `yaml:"hosts"`
+	Children map[string]*yamlGroup     `yaml:"children"`
+	Vars     map[string]any            `yaml:"vars"`
+}
+
+// inventoryTree collects groups, hosts and vars of a structured (non-INI) inventory
+type inventoryTree struct {
+	parents    map[string][]string       // group -> parent groups
+	groupVars  map[string]map[string]any // group -> vars
+	hostVars   map[string]map[string]any // host -> vars
+	hostGroups map[string][]string       // host -> groups it is defined in
+	hosts      []string                  // host names in definition order
+	parsed     map[string]*ansible.Host  // hosts parsed by go-ansible (INI inventories only), see readINITree
+
+	groupFileVars map[string]map[string]any // group -> vars from group_vars files, see loadVarsFiles
+	hostFileVars  map[string]map[string]any // host -> vars from host_vars files, see loadVarsFiles
+	defaultUser   string                    // ansible.cfg remote_user, see applyAnsibleCfg
+	defaultPort   int                       // ansible.cfg remote_port, see applyAnsibleCfg
+	paths         []string                  // inventory files the tree is read from
+}
+
+// readInventory reads an Ansible inventory source, detecting whether it is an INI or YAML file, a dynamic inventory script,
+// a Terraform state, or a directory of them.
+// http(s) inventories are downloaded first
+func readInventory(cfg *models.Config, src models.Inventory) (*ansible.Inventory, error) {
+	if utils.IsURL(src.Path) {
+		path, cleanup, err := downloadInventory(cfg, src)
+		if err != nil {
+			return nil, err
+		}
+		defer cleanup()
+		src.Path = path
+	}
+
+	tree, err := readInventoryPath(cfg, src)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.AnsibleInventory {
+		if err := tree.applyAnsibleCfg(cfg, src.Path); err != nil {
+			return nil, err
+		}
+		if err := tree.loadVarsFiles(); err != nil {
+			return nil, err
+		}
+	}
+	inv := tree.inventory()
+	inv.Paths = tree.paths
+	return inv, nil
+}
+
+// readInventoryPath reads the inventory file, or all inventory files of the directory merged into one inventory, as Ansible does,
+// so groups and vars may be split across the files
+func readInventoryPath(cfg *models.Config, src models.Inventory) (*inventoryTree, error) {
+	files, err := inventoryFiles(src.Path)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, errors.New("no inventory files found")
+	}
+	if len(files) == 1 && files[0] == src.Path {
+		return readInventoryTree(cfg, src)
+	}
+
+	tree := newInventoryTree()
+	for _, file := range files {
+		fileSrc := src
+		fileSrc.Path = file
+		fileTree, err := readInventoryTree(cfg, fileSrc)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		tree.merge(fileTree)
+	}
+	return tree, nil
+}
+
+// readInventoryTree reads the inventory file of the source type, detecting it if the type is empty
+func readInventoryTree(cfg *models.Config, src models.Inventory) (*inventoryTree, error) {
+	format := src.Type
+	if format == "" {
+		detected, err := detectInventoryFormat(src.Path)
+		if err != nil {
+			return nil, err
+		}
+		format = detected
+	}
+	utils.Debug("inventory", src.Path, "format is", format)
+
+	var tree *inventoryTree
+	var err error
+	switch format {
+	case formatINI:
+		tree, err = readINITree(cfg, src.Path)
+	case formatYAML:
+		tree, err = readYAMLTree(src.Path)
+	case formatScript:
+		tree, err = readScriptTree(src.Path, cfg.InventoryTimeout)
+	case formatTFState:
+		tree, err = readTFStateTree(src.Path, src.Attributes)
+	default:
+		return nil, fmt.Errorf("unknown inventory type %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	tree.paths = []string{src.Path}
+	return tree, nil
+}
+
+// detectInventoryFormat uses the file mode, extension, or the first meaningful line to detect the inventory format.
+// JSON content is either a Terraform state (e.g. fetched from a remote backend) or a JSON inventory
+func detectInventoryFormat(path string) (string, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer fh.Close()
+	if isScript(fh) {
+		return formatScript, nil
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml":
+		return formatYAML, nil
+	case ".ini":
+		return formatINI, nil
+	case ".tfstate":
+		return formatTFState, nil
+	}
+
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "{") {
+			return detectJSONInventoryFormat(path)
+		}
+		if line == "---" || yamlKeyRegex.MatchString(line) {
+			return formatYAML, nil
+		}
+		return formatINI, nil
+	}
+	return formatINI, scanner.Err()
+}
+
+// detectJSONInventoryFormat tells Terraform state (with the top-level version and resources) from JSON inventory, which is read as YAML
+func detectJSONInventoryFormat(path string) (string, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(contents, &keys); err != nil {
+		return "", fmt.Errorf("cannot parse JSON inventory: %w", err)
+	}
+	if keys["version"] != nil && keys["resources"] != nil {
+		return formatTFState, nil
+	}
+	return formatYAML, nil
+}
+
+// readYAMLTree parses YAML inventory (all/children/hosts tree)
+func readYAMLTree(path string) (*inventoryTree, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var groups map[string]*yamlGroup
+	if err := yaml.Unmarshal(contents, &groups); err != nil {
+		return nil, fmt.Errorf("cannot parse YAML inventory: %w", err)
+	}
+
+	tree := newInventoryTree()
+	for _, name := range slices.Sorted(maps.Keys(groups)) {
+		tree.addYAMLGroup(name, groups[name], "")
+	}
+	return tree, nil
+}
+
+func newInventoryTree() *inventoryTree {
+	return &inventoryTree{
+		parents:    map[string][]string{},
+		groupVars:  map[string]map[string]any{},
+		hostVars:   map[string]map[string]any{},
+		hostGroups: map[string][]string{},
+		parsed:     map[string]*ansible.Host{},
+
+		groupFileVars: map[string]map[string]any{},
+		hostFileVars:  map[string]map[string]any{},
+	}
+}
+
+// addGroup registers the group and links it to the parent group (if any)
+func (t *inventoryTree) addGroup(name, parent string, vars map[string]any) {
+	if _, ok := t.parents[name]; !ok {
+		t.parents[name] = []string{}
+	}
+	if parent != "" && parent != name && !slices.Contains(t.parents[name], parent) {
+		t.parents[name] = append(t.parents[name], parent)
+	}
+	if t.groupVars[name] == nil {
+		t.groupVars[name] = map[string]any{}
+	}
+	for k, v := range vars {
+		t.groupVars[name][k] = v
+	}
+}
+
+// addHost registers the host within the group
+func (t *inventoryTree) addHost(name, group string, vars map[string]any) {
+	if _, ok := t.hostVars[name]; !ok {
+		t.hostVars[name] = map[string]any{}
+		t.hosts = append(t.hosts, name)
+	}
+	if !slices.Contains(t.hostGroups[name], group) {
+		t.hostGroups[name] = append(t.hostGroups[name], group)
+	}
+	for k, v := range vars {
+		t.hostVars[name][k] = v
+	}
+}
+
+// merge adds groups, hosts and vars of the other tree, the vars of the other tree win
+func (t *inventoryTree) merge(other *inventoryTree) {
+	for _, group := range slices.Sorted(maps.Keys(other.parents)) {
+		t.addGroup(group, "", other.groupVars[group])
+		for _, parent := range other.parents[group] {
+			t.addGroup(group, parent, nil)
+		}
+	}
+	for _, host := range other.hosts {
+		for _, group := range other.hostGroups[host] {
+			t.addHost(host, group, other.hostVars[host])
+		}
+	}
+	maps.Copy(t.parsed, other.parsed)
+	t.paths = append(t.paths, other.paths...)
+}
+
+func (t *inventoryTree) addYAMLGroup(name string, group *yamlGroup, parent string) {
+	if group == nil {
+		t.addGroup(name, parent, nil)
+		return
+	}
+	t.addGroup(name, parent, group.Vars)
+	for _, host := range slices.Sorted(maps.Keys(group.Hosts)) {
+		t.addHost(host, name, group.Hosts[host])
+	}
+	for _, child := range slices.Sorted(maps.Keys(group.Children)) {
+		t.addYAMLGroup(child, group.Children[child], name)
+	}
+}
+
+// ancestors returns the group itself and all of its parent groups
+func (t *inventoryTree) ancestors(groups []string) []string {
+	all := []string{}
+	queue := append([]string{}, groups...)
+	for len(queue) > 0 {
+		group := queue[0]
+		queue = queue[1:]
+		if slices.Contains(all, group) {
+			continue
+		}
+		all = append(all, group)
+		queue = append(queue, t.parents[group]...)
+	}
+	if !slices.Contains(all, groupAll) {
+		all = append(all, groupAll)
+	}
+	return all
+}
+
+// depth returns the longest distance from the group to the "all" group, used to order group vars
+func (t *inventoryTree) depth(group string, seen map[string]bool) int {
+	if group == groupAll || seen[group] {
+		return 0
+	}
+	seen[group] = true
+	defer delete(seen, group)
+
+	maxDepth := 0
+	for _, parent := range t.parents[group] {
+		maxDepth = max(maxDepth, t.depth(parent, seen))
+	}
+	return maxDepth + 1
+}
+
+// orderedGroups sorts the groups from the least specific ("all") to the most specific, by their depth and then by name, as Ansible does
+func (t *inventoryTree) orderedGroups(groups []string) []string {
+	ordered := slices.Clone(groups)
+	depths := make(map[string]int, len(ordered))
+	for _, group := range ordered {
+		depths[group] = t.depth(group, map[string]bool{})
+	}
+	slices.SortStableFunc(ordered, func(a, b string) int {
+		if depths[a] != depths[b] {
+			return depths[a] - depths[b]
+		}
+		return strings.Compare(a, b)
+	})
+	return ordered
+}
+
+// vars merges the host vars in the Ansible precedence order (later wins): inventory group vars, group_vars files
+// (both from the least specific group to the most specific one), inventory host vars, host_vars files
+func (t *inventoryTree) vars(host string, groups []string) ansible.HostVars {
+	ordered := t.orderedGroups(groups)
+	vars := ansible.HostVars{}
+	for _, group := range ordered {
+		mergeVars(vars, t.groupVars[group])
+	}
+	for _, group := range ordered {
+		mergeVars(vars, t.groupFileVars[group])
+	}
+	mergeVars(vars, t.hostVars[host])
+	mergeVars(vars, t.hostFileVars[host])
+	return vars
+}
+
+// inventory converts the tree into the go-ansible inventory, in the same shape as the INI parser produces
+func (t *inventoryTree) inventory() *ansible.Inventory {
+	inv := &ansible.Inventory{
+		Groups:    map[string][]*ansible.Host{},
+		GroupVars: map[string]map[string]string{},
+		GroupTree: map[string][]string{},
+		Hosts:     map[string]*ansible.Host{},
+	}
+	for group, parents := range t.parents {
+		if _, ok := inv.GroupTree[group]; !ok {
+			inv.GroupTree[group] = []string{}
+		}
+		for _, parent := range parents {
+			inv.GroupTree[parent] = append(inv.GroupTree[parent], group)
+		}
+		inv.GroupVars[group] = map[string]string{}
+		for k, v := range t.groupVars[group] {
+			inv.GroupVars[group][k] = varString(v)
+		}
+	}
+
+	for _, name := range t.hosts {
+		host := t.host(name)
+		inv.Hosts[name] = host
+		for _, group := range host.Groups {
+			inv.Groups[group] = append(inv.Groups[group], host)
+		}
+	}
+	return inv
+}
+
+// host builds the go-ansible host. The main group and the address of the hosts parsed by go-ansible are kept,
+// unless ansible_host is overridden in the merged vars
+func (t *inventoryTree) host(name string) *ansible.Host {
+	group, address := t.hostGroups[name][0], ""
+	if parsed := t.parsed[name]; parsed != nil {
+		group, address = parsed.Group, parsed.Host
+	}
+	if group == groupAll {
+		group = groupUngrouped
+	}
+	groups := t.ancestors(append(slices.Clone(t.hostGroups[name]), group))
+	vars := t.vars(name, groups)
+
+	port, err := strconv.Atoi(varString(vars["ansible_port"]))
+	if err != nil || port == 0 {
+		port = cmp.Or(t.defaultPort, 22)
+	}
+	user := cmp.Or(varString(vars["ansible_user"]), t.defaultUser, "root")
+	slices.Sort(groups)
+
+	return &ansible.Host{
+		Vars:   vars,
+		Group:  group,
+		Groups: groups,
+		Name:   name,
+		Host:   cmp.Or(varString(vars["ansible_host"]), address),
+		Port:   port,
+		User:   user,
+	}
+}
+
+// varString converts scalar inventory var into string
+func varString(v any) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case int, int64, uint64, float64, bool:
+		return fmt.Sprint(value)
+	default:
+		return ""
+	}
+}
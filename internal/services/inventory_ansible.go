@@ -0,0 +1,207 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/etkecc/go-ansible"
+	"github.com/etkecc/go-kit"
+	"gopkg.in/yaml.v3"
+
+	"github.com/etkecc/inventory-wg-sync/internal/models"
+	"github.com/etkecc/inventory-wg-sync/internal/utils"
+)
+
+// varsFileExts are the extensions of group_vars and host_vars files, the same as Ansible uses
+var varsFileExts = []string{"", ".yml", ".yaml", ".json"}
+
+// ansibleCfgInventories returns the inventories listed in the ansible.cfg files of the sources (see ansibleCfgPath),
+// skipping the ones that are among the sources already, so each inventory is read once, as a source of its own.
+// Errors are returned for required sources only
+func ansibleCfgInventories(cfg *models.Config, srcs []models.Inventory) ([]models.Inventory, error) {
+	seen := make(map[string]bool, len(srcs))
+	for _, src := range srcs {
+		seen[src.Path] = true
+	}
+	cfgPaths := map[string]bool{}
+	extra := []models.Inventory{}
+	for _, src := range srcs {
+		cfgPath := ansibleCfgPath(cfg, src.Path)
+		if cfgPaths[cfgPath] {
+			continue
+		}
+		cfgPaths[cfgPath] = true
+		acfg, err := readAnsibleCfg(cfgPath)
+		if err != nil {
+			if err = inventoryError(src.Path, src.IsRequired(cfg.InventoriesRequired), err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		for _, path := range ansibleCfgInventoryPaths(acfg, cfgPath) {
+			if !seen[path] {
+				seen[path] = true
+				extra = append(extra, models.Inventory{Path: path})
+			}
+		}
+	}
+	return extra, nil
+}
+
+// ansibleCfgPath returns the ansible.cfg path of the inventory: set in the config, or located in the parent dir of the inventory dir.
+// http(s) inventories are downloaded into a temp dir, so they have no ansible.cfg of their own
+func ansibleCfgPath(cfg *models.Config, path string) string {
+	if cfg.AnsibleCfg != "" || utils.IsURL(path) {
+		return cfg.AnsibleCfg
+	}
+	dir := path
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		dir = filepath.Dir(path)
+	}
+	return filepath.Join(filepath.Dir(dir), "ansible.cfg")
+}
+
+// readAnsibleCfg reads the ansible.cfg file, a missing file is not an error
+func readAnsibleCfg(path string) (*ansible.Cfg, error) {
+	acfg, err := ansible.NewAnsibleCfgFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s: %w", path, err)
+	}
+	return acfg, nil
+}
+
+// ansibleCfgInventoryPaths returns the inventory paths listed in ansible.cfg, relative paths are resolved against the ansible.cfg dir
+func ansibleCfgInventoryPaths(acfg *ansible.Cfg, cfgPath string) []string {
+	if acfg == nil {
+		return nil
+	}
+	paths := []string{}
+	for _, path := range strings.Split(acfg.Config["defaults"]["inventory"], ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(cfgPath), path)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// applyAnsibleCfg applies the remote_user and remote_port defaults of the inventory's ansible.cfg, see ansibleCfgPath.
+// The inventories listed in ansible.cfg are read as sources of their own, see ansibleCfgInventories
+func (t *inventoryTree) applyAnsibleCfg(cfg *models.Config, path string) error {
+	acfg, err := readAnsibleCfg(ansibleCfgPath(cfg, path))
+	if err != nil || acfg == nil {
+		return err
+	}
+	defaults := acfg.Config["defaults"]
+	t.defaultUser = defaults["remote_user"]
+	if port, err := strconv.Atoi(defaults["remote_port"]); err == nil {
+		t.defaultPort = port
+	}
+	return nil
+}
+
+// loadVarsFiles reads group_vars and host_vars files located next to the inventory files, see inventoryTree.vars for the precedence
+func (t *inventoryTree) loadVarsFiles() error {
+	dirs := make([]string, 0, len(t.paths))
+	for _, path := range t.paths {
+		dirs = append(dirs, filepath.Dir(path))
+	}
+	dirs = kit.Uniq(dirs)
+
+	var err error
+	groups := append(slices.Sorted(maps.Keys(t.parents)), groupAll, groupUngrouped)
+	for _, group := range kit.Uniq(groups) {
+		if t.groupFileVars[group], err = readDirsVars(dirs, "group_vars", group); err != nil {
+			return err
+		}
+	}
+	for _, host := range t.hosts {
+		if t.hostFileVars[host], err = readDirsVars(dirs, "host_vars", host); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readDirsVars reads vars of the group or host from the vars dir (group_vars or host_vars) of each inventory dir
+func readDirsVars(dirs []string, varsDir, name string) (map[string]any, error) {
+	vars := map[string]any{}
+	for _, dir := range dirs {
+		dirVars, err := readVarsPath(filepath.Join(dir, varsDir, name))
+		if err != nil {
+			return nil, err
+		}
+		mergeVars(vars, dirVars)
+	}
+	return vars, nil
+}
+
+// readVarsPath reads vars from the "name", "name.yml", "name.yaml", "name.json" files or all files in the "name" dir
+func readVarsPath(base string) (map[string]any, error) {
+	vars := map[string]any{}
+	for _, ext := range varsFileExts {
+		info, err := os.Stat(base + ext)
+		if err != nil {
+			continue
+		}
+		files := []string{base + ext}
+		if info.IsDir() {
+			if files, err = varsDirFiles(base + ext); err != nil {
+				return nil, err
+			}
+		}
+		for _, file := range files {
+			fileVars, err := readVarsFile(file)
+			if err != nil {
+				return nil, err
+			}
+			mergeVars(vars, fileVars)
+		}
+	}
+	return vars, nil
+}
+
+// varsDirFiles returns the vars files of the dir, sorted by name
+func varsDirFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read vars dir %s: %w", dir, err)
+	}
+	files := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() && slices.Contains(varsFileExts, filepath.Ext(entry.Name())) {
+			files = append(files, filepath.Join(dir, entry.Name()))
+		}
+	}
+	return files, nil
+}
+
+func readVarsFile(path string) (map[string]any, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read vars file %s: %w", path, err)
+	}
+	var vars map[string]any
+	if err := yaml.Unmarshal(contents, &vars); err != nil {
+		return nil, fmt.Errorf("cannot parse vars file %s: %w", path, err)
+	}
+	return vars, nil
+}
+
+func mergeVars(dst, src map[string]any) {
+	for k, v := range src {
+		dst[k] = v
+	}
+}
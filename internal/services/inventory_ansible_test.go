@@ -0,0 +1,204 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/etkecc/inventory-wg-sync/internal/models"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			t.Fatalf("MkdirAll() error = %v", err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+}
+
+func TestReadInventory_AnsibleINI(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"inventory/hosts":                   "[prod]\nweb1\nweb2 ansible_host=1.2.3.4 wg=\"['a', 'b']\"\nweb3 ansible_host=1.1.1.1\n",
+		"inventory/host_vars/web1.yml":      "ansible_host: 5.6.7.8\n",
+		"inventory/host_vars/web2/vars.yml": "ansible_host: 9.9.9.9\n",
+		"inventory/group_vars/prod.yml":     "ansible_user: deploy\n",
+	})
+
+	inv, err := readInventory(&models.Config{AnsibleInventory: true}, models.Inventory{Path: filepath.Join(dir, "inventory", "hosts")})
+	if err != nil {
+		t.Fatalf("readInventory() error = %v", err)
+	}
+
+	want := map[string]string{"web1": "5.6.7.8", "web2": "9.9.9.9", "web3": "1.1.1.1"}
+	got := map[string]string{}
+	for name, host := range inv.Hosts {
+		got[name] = host.Host
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("readInventory() hosts = %#v, want %#v", got, want)
+	}
+	if user := inv.Hosts["web1"].Vars.String("ansible_user"); user != "deploy" {
+		t.Fatalf("web1 ansible_user = %q, want group_vars value", user)
+	}
+	if wg := inv.Hosts["web2"].Vars.StringSlice("wg"); !reflect.DeepEqual(wg, []string{"a", "b"}) {
+		t.Fatalf("web2 inline list var = %#v", wg)
+	}
+}
+
+func TestReadInventory_AnsibleYAML(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"inventory.yml":             "all:\n  children:\n    prod:\n      hosts:\n        web1:\n",
+		"group_vars/all/main.yml":   "ansible_host: 1.1.1.1\n",
+		"group_vars/prod":           "ansible_host: 2.2.2.2\n",
+		"host_vars/web1/ignored.md": "not: vars",
+	})
+
+	inv, err := readInventory(&models.Config{AnsibleInventory: true}, models.Inventory{Path: filepath.Join(dir, "inventory.yml")})
+	if err != nil {
+		t.Fatalf("readInventory() error = %v", err)
+	}
+	if host := inv.Hosts["web1"]; host == nil || host.Host != "2.2.2.2" {
+		t.Fatalf("web1 = %#v, want address from group_vars/prod", host)
+	}
+}
+
+func TestReadInventory_AnsibleYAMLAnsibleCfg(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"ansible.cfg":             "[defaults]\ninventory = extra/hosts\nremote_user = deploy\nremote_port = 2222\n",
+		"inventory/hosts.yml":     "all:\n  hosts:\n    web1:\n      ansible_host: 1.1.1.1\n",
+		"extra/hosts":             "[db]\ndb1\n",
+		"extra/host_vars/db1.yml": "ansible_host: 10.0.0.1\n",
+	})
+
+	inv, err := readInventory(&models.Config{AnsibleInventory: true}, models.Inventory{Path: filepath.Join(dir, "inventory", "hosts.yml")})
+	if err != nil {
+		t.Fatalf("readInventory() error = %v", err)
+	}
+	if web1 := inv.Hosts["web1"]; web1 == nil || web1.User != "deploy" || web1.Port != 2222 {
+		t.Fatalf("web1 = %#v, want ansible.cfg defaults", web1)
+	}
+	// the ansible.cfg inventories are read as sources of their own, see TestAllowedIPs_AnsibleCfgInventories
+	if db1 := inv.Hosts["db1"]; db1 != nil {
+		t.Fatalf("db1 = %#v, want no hosts of the ansible.cfg inventory", db1)
+	}
+}
+
+func TestAllowedIPs_AnsibleCfgInventories(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"ansible.cfg":             "[defaults]\ninventory = shared/hosts,extra/inventory\n",
+		"a/hosts":                 "a1 ansible_host=1.1.1.1\n",
+		"b/hosts":                 "b1 ansible_host=2.2.2.2\n",
+		"shared/hosts":            "s1 ansible_host=3.3.3.3\n",
+		"extra/inventory":         "[db]\ndb1\n",
+		"extra/host_vars/db1.yml": "ansible_host: 4.4.4.4\n",
+	})
+	cfg := &models.Config{AnsibleInventory: true, InventoryPaths: []string{filepath.Join(dir, "*", "hosts")}}
+	got, err := AllowedIPs(cfg)
+	if err != nil {
+		t.Fatalf("AllowedIPs() error = %v", err)
+	}
+	// each inventory is read once, even if it is listed in ansible.cfg and matched by the glob as well
+	if want := []string{"1.1.1.1/32", "2.2.2.2/32", "3.3.3.3/32", "4.4.4.4/32"}; !reflect.DeepEqual(prefixStrings(got.AllowedIPs), want) {
+		t.Fatalf("AllowedIPs() = %#v, want %#v", prefixStrings(got.AllowedIPs), want)
+	}
+	if got.Hosts != 4 {
+		t.Fatalf("AllowedIPs() hosts = %d, want 4", got.Hosts)
+	}
+}
+
+func TestReadInventory_AnsibleVarsPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"hosts": `[db]
+web1
+[app]
+web1
+web2 ansible_host=5.5.5.5
+[zone:children]
+app
+[web]
+web3
+[web:vars]
+ansible_host=1.1.1.1
+`,
+		"group_vars/web.yml":  "ansible_host: 2.2.2.2\n",
+		"group_vars/zone.yml": "ansible_host: 3.3.3.3\n",
+		"group_vars/app.yml":  "ansible_host: 4.4.4.4\n",
+		"host_vars/web2.yml":  "ansible_user: deploy\n",
+	})
+
+	inv, err := readInventory(&models.Config{AnsibleInventory: true}, models.Inventory{Path: filepath.Join(dir, "hosts")})
+	if err != nil {
+		t.Fatalf("readInventory() error = %v", err)
+	}
+	// group_vars files win over inventory group vars, deeper groups win over their parents, inventory host vars win over group_vars files
+	want := map[string]string{"web1": "4.4.4.4", "web2": "5.5.5.5", "web3": "2.2.2.2"}
+	got := map[string]string{}
+	for name, host := range inv.Hosts {
+		got[name] = host.Host
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("readInventory() hosts = %#v, want %#v", got, want)
+	}
+	if user := inv.Hosts["web2"].User; user != "deploy" {
+		t.Fatalf("web2 user = %q, want host_vars value", user)
+	}
+}
+
+func TestReadInventory_AnsibleInvalidYAML(t *testing.T) {
+	path := writeInventory(t, "hosts.yml", "all: [")
+	if _, err := readInventory(&models.Config{AnsibleInventory: true}, models.Inventory{Path: path}); err == nil {
+		t.Fatalf("readInventory() expected error for invalid YAML")
+	}
+}
+
+func TestReadVarsPath_InvalidFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"host_vars/web1.yml": "ansible_host: ["})
+	if _, err := readVarsPath(filepath.Join(dir, "host_vars", "web1")); err == nil {
+		t.Fatalf("readVarsPath() expected error for invalid vars file")
+	}
+}
+
+func TestAllowedIPs_AnsibleRequiredErrors(t *testing.T) {
+	tests := map[string]map[string]string{
+		"missing ansible.cfg inventory": {
+			"ansible.cfg": "[defaults]\ninventory = missing/hosts\n",
+			"a/hosts":     "a1 ansible_host=1.1.1.1\n",
+		},
+		"invalid group_vars": {
+			"a/hosts":              "a1 ansible_host=1.1.1.1\n",
+			"a/group_vars/all.yml": "ansible_host: [broken\n",
+		},
+		"invalid host_vars": {
+			"a/hosts":            "a1 ansible_host=1.1.1.1\n",
+			"a/host_vars/a1.yml": "ansible_host: [broken\n",
+		},
+	}
+	for name, files := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, files)
+			path := filepath.Join(dir, "a", "hosts")
+			cfg := &models.Config{AnsibleInventory: true, InventoriesRequired: true, InventoryPaths: []string{path}}
+			if _, err := AllowedIPs(cfg); err == nil {
+				t.Fatalf("AllowedIPs() expected error for required inventory")
+			}
+
+			cfg.InventoriesRequired = false
+			if _, err := AllowedIPs(cfg); err != nil {
+				t.Fatalf("AllowedIPs() error = %v, want it logged for optional inventory", err)
+			}
+		})
+	}
+}
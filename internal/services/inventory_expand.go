@@ -0,0 +1,76 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/etkecc/inventory-wg-sync/internal/models"
+	"github.com/etkecc/inventory-wg-sync/internal/utils"
+)
+
+var (
+	// inventoryIgnoreNames are skipped in the inventory dirs, same as Ansible does
+	inventoryIgnoreNames = regexp.MustCompile(`^(\..*|host_vars|group_vars|vars_plugins)$`)
+	// inventoryIgnoreExts are the default Ansible INVENTORY_IGNORE_EXTS
+	inventoryIgnoreExts = []string{".pyc", ".pyo", ".swp", ".bak", "~", ".rpm", ".md", ".txt", ".rst", ".orig", ".cfg", ".retry"}
+)
+
+// expandInventory expands glob pattern of the inventory source into inventory files and directories,
+// each directory is read as one inventory, see readInventoryPath.
+// Paths that don't exist and http(s) URLs are returned as is, so the error is reported when the inventory is read
+func expandInventory(src models.Inventory) ([]models.Inventory, error) {
+	if utils.IsURL(src.Path) || !strings.ContainsAny(src.Path, "*?[") {
+		return []models.Inventory{src}, nil
+	}
+	matches, err := filepath.Glob(src.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	expanded := make([]models.Inventory, 0, len(matches))
+	for _, path := range matches {
+		matchSrc := src
+		matchSrc.Path = path
+		expanded = append(expanded, matchSrc)
+	}
+	return expanded, nil
+}
+
+// inventoryFiles returns the path itself if it is not a directory, otherwise all inventory files in it (recursively, sorted by name)
+func inventoryFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil || !info.IsDir() { // stat errors are reported when the inventory is read
+		return []string{path}, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	for _, entry := range entries {
+		if inventoryIgnored(entry.Name()) {
+			utils.Debug("inventory", filepath.Join(path, entry.Name()), "is ignored")
+			continue
+		}
+		entryFiles, err := inventoryFiles(filepath.Join(path, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, entryFiles...)
+	}
+	return files, nil
+}
+
+// inventoryIgnored tells if the file or dir in the inventory dir should be skipped
+func inventoryIgnored(name string) bool {
+	if inventoryIgnoreNames.MatchString(name) {
+		return true
+	}
+	return slices.ContainsFunc(inventoryIgnoreExts, func(ext string) bool {
+		return strings.HasSuffix(name, ext)
+	})
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/etkecc/inventory-wg-sync/internal/models"
+)
+
+func TestExpandInventory(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"customers/a/hosts":   "a1 ansible_host=1.1.1.1\n",
+		"customers/b/hosts":   "b1 ansible_host=2.2.2.2\n",
+		"inventory/hosts.yml": "all:\n",
+	})
+
+	required := true
+	tests := []struct {
+		src  models.Inventory
+		want []models.Inventory
+	}{
+		{
+			src: models.Inventory{Path: filepath.Join(dir, "customers", "*", "hosts")},
+			want: []models.Inventory{
+				{Path: filepath.Join(dir, "customers", "a", "hosts")},
+				{Path: filepath.Join(dir, "customers", "b", "hosts")},
+			},
+		},
+		{
+			src:  models.Inventory{Path: filepath.Join(dir, "inventory"), Type: formatINI, Required: &required},
+			want: []models.Inventory{{Path: filepath.Join(dir, "inventory"), Type: formatINI, Required: &required}},
+		},
+		{
+			src:  models.Inventory{Path: filepath.Join(dir, "*"), Type: formatINI},
+			want: []models.Inventory{{Path: filepath.Join(dir, "customers"), Type: formatINI}, {Path: filepath.Join(dir, "inventory"), Type: formatINI}},
+		},
+		{
+			src:  models.Inventory{Path: filepath.Join(dir, "missing")},
+			want: []models.Inventory{{Path: filepath.Join(dir, "missing")}},
+		},
+		{
+			src:  models.Inventory{Path: filepath.Join(dir, "nothing", "*")},
+			want: []models.Inventory{},
+		},
+	}
+	for _, tt := range tests {
+		got, err := expandInventory(tt.src)
+		if err != nil {
+			t.Fatalf("expandInventory(%q) error = %v", tt.src.Path, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("expandInventory(%q) = %#v, want %#v", tt.src.Path, got, tt.want)
+		}
+	}
+}
+
+func TestInventoryFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"hosts.yml":               "all:\n",
+		"prod/hosts":              "",
+		"hosts.bak":               "",
+		"hosts~":                  "",
+		".hidden":                 "",
+		"group_vars/all.yml":      "",
+		"host_vars/web1/vars.yml": "",
+		"ansible.cfg":             "",
+		"README.md":               "docs",
+	})
+
+	got, err := inventoryFiles(dir)
+	if err != nil {
+		t.Fatalf("inventoryFiles() error = %v", err)
+	}
+	if want := []string{filepath.Join(dir, "hosts.yml"), filepath.Join(dir, "prod", "hosts")}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("inventoryFiles() = %#v, want %#v", got, want)
+	}
+}
+
+func TestExpandInventory_InvalidPattern(t *testing.T) {
+	if _, err := expandInventory(models.Inventory{Path: "/etc/[ansible"}); err == nil {
+		t.Fatalf("expandInventory() expected error for invalid pattern")
+	}
+}
+
+func TestAllowedIPs_InventoryDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"customers/a/hosts": "a1 ansible_host=1.1.1.1\n",
+		"customers/b/hosts": "b1 ansible_host=2.2.2.2\n",
+		"extra/hosts.yml":   "all:\n  hosts:\n    c1:\n      ansible_host: 3.3.3.3\n",
+	})
+	cfg := &models.Config{InventoryPaths: []string{filepath.Join(dir, "customers", "*", "hosts"), filepath.Join(dir, "extra")}}
+	got, err := AllowedIPs(cfg)
+	if err != nil {
+		t.Fatalf("AllowedIPs() error = %v", err)
+	}
+	if want := []string{"1.1.1.1/32", "2.2.2.2/32", "3.3.3.3/32"}; !reflect.DeepEqual(prefixStrings(got.AllowedIPs), want) {
+		t.Fatalf("AllowedIPs() = %#v, want %#v", got.AllowedIPs, want)
+	}
+}
+
+func TestAllowedIPs_InventoryDirSplitGroups(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"inventory/hosts":      "[web]\nweb1 ansible_host=1.1.1.1\n[db]\ndb1 ansible_host=2.2.2.2\n[legacy]\nold1 ansible_host=3.3.3.3\n",
+		"inventory/groups.ini": "[prod:children]\nweb\nlegacy\n\n[legacy:vars]\nwg_sync_skip=true\n",
+	})
+	cfg := &models.Config{InventoryPaths: []string{filepath.Join(dir, "inventory")}, IncludeGroups: []string{"prod"}}
+	got, err := AllowedIPs(cfg)
+	if err != nil {
+		t.Fatalf("AllowedIPs() error = %v", err)
+	}
+	if want := []string{"1.1.1.1/32"}; !reflect.DeepEqual(prefixStrings(got.AllowedIPs), want) {
+		t.Fatalf("AllowedIPs() = %#v, want %#v", prefixStrings(got.AllowedIPs), want)
+	}
+}
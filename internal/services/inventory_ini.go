@@ -0,0 +1,149 @@
+package services
+
+import (
+	"bufio"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/etkecc/go-ansible"
+	"github.com/etkecc/go-kit"
+	"gopkg.in/yaml.v3"
+
+	"github.com/etkecc/inventory-wg-sync/internal/models"
+)
+
+// readINITree reads the INI inventory with go-ansible (see readINIInventory) and adds what go-ansible skips:
+// hosts without ansible_host, all groups of the hosts listed in several groups, and inline vars other than the connection ones
+func readINITree(cfg *models.Config, path string) (*inventoryTree, error) {
+	tree, err := parseINITree(path)
+	if err != nil {
+		return nil, err
+	}
+	// go-ansible logs inventories without hosts, and it takes only the hosts with ansible_host on the host line
+	if !slices.ContainsFunc(tree.hosts, func(host string) bool { return tree.hostVars[host]["ansible_host"] != nil }) {
+		return tree, nil
+	}
+	inv, err := readINIInventory(cfg, path)
+	if err != nil {
+		return nil, err
+	}
+	tree.parsed = inv.Hosts
+	return tree, nil
+}
+
+// readINIInventory parses the INI inventory with go-ansible, using ansible.ParseInventory if ansible_inventory is enabled
+func readINIInventory(cfg *models.Config, path string) (*ansible.Inventory, error) {
+	if cfg.AnsibleInventory {
+		return parseAnsibleInventory(cfg.AnsibleCfg, path), nil
+	}
+	return ansible.NewHostsFile(path, &ansible.Host{})
+}
+
+// parseAnsibleInventory loads the INI inventory with the ansible.cfg defaults.
+// Vars files are applied separately, see loadVarsFiles
+func parseAnsibleInventory(ansibleCfg, path string) *ansible.Inventory {
+	inv := ansible.ParseInventory(ansibleCfg, path, "")
+	if inv == nil {
+		inv = &ansible.Inventory{Hosts: map[string]*ansible.Host{}}
+	}
+	return inv
+}
+
+// parseINITree parses INI inventory into the tree, keeping all host vars and hosts without ansible_host
+func parseINITree(path string) (*inventoryTree, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	tree := newInventoryTree()
+	section, kind := groupUngrouped, ""
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section, kind, _ = strings.Cut(strings.Trim(line, "[]"), ":")
+			tree.addGroup(section, "", nil)
+			continue
+		}
+
+		switch kind {
+		case "children":
+			tree.addGroup(strings.Fields(line)[0], section, nil)
+		case "vars":
+			k, v, _ := strings.Cut(line, "=")
+			tree.addGroup(section, "", map[string]any{strings.TrimSpace(k): parseINIValue(strings.TrimSpace(v))})
+		default:
+			name, vars := parseINIHost(line)
+			tree.addHost(name, section, vars)
+		}
+	}
+	return tree, scanner.Err()
+}
+
+// parseINIHost parses the "name[:port] key1=value1 key2=value2" host line
+func parseINIHost(line string) (name string, vars map[string]any) {
+	fields := splitINIFields(line)
+	vars = make(map[string]any, len(fields))
+	name = fields[0]
+	if host, port, ok := strings.Cut(name, ":"); ok && !strings.Contains(port, ":") {
+		name = host
+		vars["ansible_port"] = port
+	}
+	for _, field := range fields[1:] {
+		k, v, ok := strings.Cut(field, "=")
+		if !ok {
+			continue
+		}
+		vars[k] = parseINIValue(v)
+	}
+	return name, vars
+}
+
+// splitINIFields splits the line by whitespace, keeping quoted values together
+func splitINIFields(line string) []string {
+	var fields []string
+	var field strings.Builder
+	var quote rune
+	for _, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == ' ' || r == '\t':
+			if field.Len() > 0 {
+				fields = append(fields, field.String())
+				field.Reset()
+			}
+			continue
+		}
+		field.WriteRune(r)
+	}
+	if field.Len() > 0 {
+		fields = append(fields, field.String())
+	}
+	return fields
+}
+
+// parseINIValue unquotes the INI value and parses lists and dicts, e.g. ['1.2.3.4', '5.6.7.8']
+func parseINIValue(value string) any {
+	if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+		value = value[1 : len(value)-1]
+	}
+	value = kit.Unquote(value)
+	if strings.HasPrefix(value, "[") || strings.HasPrefix(value, "{") {
+		var parsed any
+		if err := yaml.Unmarshal([]byte(value), &parsed); err == nil {
+			return parsed
+		}
+	}
+	return value
+}
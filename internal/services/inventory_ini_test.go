@@ -0,0 +1,59 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/etkecc/inventory-wg-sync/internal/models"
+)
+
+func TestReadINITree(t *testing.T) {
+	path := writeInventory(t, "hosts", `
+# comment
+top:2222 ansible_host=1.2.3.4
+[web]
+web1 ansible_host="5.6.7.8" flag
+nohost wg_sync_skip=true
+
+[prod:children]
+web
+
+[prod:vars]
+ansible_user = deploy
+
+[db]
+web1 ansible_host=5.6.7.8
+`)
+	tree, err := readINITree(&models.Config{}, path)
+	if err != nil {
+		t.Fatalf("readINITree() error = %v", err)
+	}
+
+	top := tree.host("top")
+	if top.Group != groupUngrouped || top.Port != 2222 || top.Host != "1.2.3.4" {
+		t.Fatalf("top = %#v", top)
+	}
+	web1 := tree.host("web1")
+	// go-ansible keeps the last group of the host as its main group
+	if web1.Host != "5.6.7.8" || web1.User != "deploy" || web1.Group != "db" {
+		t.Fatalf("web1 = %#v", web1)
+	}
+	if want := []string{"all", "db", "prod", "web"}; !reflect.DeepEqual(web1.Groups, want) {
+		t.Fatalf("web1 groups = %#v, want %#v", web1.Groups, want)
+	}
+	// hosts without ansible_host, skipped by go-ansible, are kept with their inline vars
+	if nohost := tree.host("nohost"); nohost.Host != "" || !hostSkipped(nohost) {
+		t.Fatalf("nohost = %#v", nohost)
+	}
+}
+
+func TestSplitINIFields(t *testing.T) {
+	got := splitINIFields(`web1  ansible_host='1.2.3.4' list="['a', 'b']"	flag`)
+	want := []string{"web1", "ansible_host='1.2.3.4'", `list="['a', 'b']"`, "flag"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("splitINIFields() = %#v, want %#v", got, want)
+	}
+	if v := parseINIValue("'1.2.3.4'"); v != "1.2.3.4" {
+		t.Fatalf("parseINIValue() = %#v, want unquoted value", v)
+	}
+}
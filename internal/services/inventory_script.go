@@ -0,0 +1,148 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	formatScript = "script"
+
+	defaultScriptTimeout = 30 * time.Second
+	scriptWaitDelay      = time.Second
+)
+
+// scriptGroup is a group in the dynamic inventory JSON output
+type scriptGroup struct {
+	Hosts    []string       `json:"hosts"`
+	Children []string       `json:"children"`
+	Vars     map[string]any `json:"vars"`
+}
+
+// scriptMeta is the "_meta" section of the dynamic inventory JSON output
+type scriptMeta struct {
+	HostVars map[string]map[string]any `json:"hostvars"`
+}
+
+// isScript tells if the file is an executable with a shebang, the same way Ansible treats dynamic inventories
+func isScript(fh *os.File) bool {
+	info, err := fh.Stat()
+	if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0o111 == 0 {
+		return false
+	}
+	shebang := make([]byte, 2)
+	if _, err := io.ReadFull(fh, shebang); err != nil {
+		return false
+	}
+	if _, err := fh.Seek(0, io.SeekStart); err != nil {
+		return false
+	}
+	return string(shebang) == "#!"
+}
+
+// readScriptTree runs the dynamic inventory script with --list and parses its JSON output.
+// If the output has no "_meta.hostvars", the script is called with --host for each host, as Ansible does
+func readScriptTree(path string, timeout time.Duration) (*inventoryTree, error) {
+	if timeout <= 0 {
+		timeout = defaultScriptTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	output, err := runInventoryScript(ctx, path, "--list")
+	if err != nil {
+		return nil, err
+	}
+	var data map[string]json.RawMessage
+	if err := json.Unmarshal(output, &data); err != nil {
+		return nil, fmt.Errorf("cannot parse inventory script %s output: %w", path, err)
+	}
+
+	tree := newInventoryTree()
+	var meta *scriptMeta
+	for _, name := range slices.Sorted(maps.Keys(data)) {
+		if name == "_meta" {
+			meta = &scriptMeta{}
+			if err := json.Unmarshal(data[name], meta); err != nil {
+				return nil, fmt.Errorf("cannot parse inventory script %s _meta: %w", path, err)
+			}
+			continue
+		}
+		group, err := parseScriptGroup(data[name])
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse inventory script %s group %s: %w", path, name, err)
+		}
+		tree.addGroup(name, "", group.Vars)
+		for _, host := range group.Hosts {
+			tree.addHost(host, name, nil)
+		}
+		for _, child := range group.Children {
+			tree.addGroup(child, name, nil)
+		}
+	}
+
+	for _, host := range tree.hosts {
+		vars, err := scriptHostVars(ctx, path, host, meta)
+		if err != nil {
+			return nil, err
+		}
+		mergeVars(tree.hostVars[host], vars)
+	}
+
+	return tree, nil
+}
+
+// parseScriptGroup parses the group, which may be either an object with hosts, vars, and children, or a list of hosts
+func parseScriptGroup(raw json.RawMessage) (*scriptGroup, error) {
+	group := &scriptGroup{}
+	if err := json.Unmarshal(raw, group); err == nil {
+		return group, nil
+	}
+	if err := json.Unmarshal(raw, &group.Hosts); err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+// scriptHostVars returns host vars from the _meta section, or calls the script with --host if there is no _meta
+func scriptHostVars(ctx context.Context, path, host string, meta *scriptMeta) (map[string]any, error) {
+	if meta != nil {
+		return meta.HostVars[host], nil
+	}
+
+	output, err := runInventoryScript(ctx, path, "--host", host)
+	if err != nil {
+		return nil, err
+	}
+	var vars map[string]any
+	if err := json.Unmarshal(output, &vars); err != nil {
+		return nil, fmt.Errorf("cannot parse inventory script %s output for host %s: %w", path, host, err)
+	}
+	return vars, nil
+}
+
+// runInventoryScript runs the script and returns its stdout, error contains the script's stderr
+func runInventoryScript(ctx context.Context, path string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.WaitDelay = scriptWaitDelay // don't wait for the children of the killed script holding stdout open
+	if err := cmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("inventory script %s %s timed out", path, strings.Join(args, " "))
+		}
+		return nil, fmt.Errorf("inventory script %s %s failed: %w: %s", path, strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/etkecc/inventory-wg-sync/internal/models"
+)
+
+func writeScript(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "inventory.py")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+contents), 0o700); err != nil { //nolint:gosec // test script
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	return path
+}
+
+func TestReadInventory_Script(t *testing.T) {
+	path := writeScript(t, `[ "$1" = "--list" ] || exit 1
+cat <<'JSON'
+{
+  "all": {"children": ["prod", "ungrouped"], "vars": {"ansible_user": "admin"}},
+  "prod": {"hosts": ["web1", "web2"], "vars": {"ansible_port": 2222}},
+  "ungrouped": ["db1"],
+  "_meta": {"hostvars": {
+    "web1": {"ansible_host": "1.1.1.1"},
+    "web2": {"ansible_host": "2.2.2.2", "wg_sync_skip": true},
+    "db1": {"ansible_host": "3.3.3.3"}
+  }}
+}
+JSON
+`)
+	inv, err := readInventory(&models.Config{}, models.Inventory{Path: path})
+	if err != nil {
+		t.Fatalf("readInventory() error = %v", err)
+	}
+	web1 := inv.Hosts["web1"]
+	if web1 == nil || web1.Host != "1.1.1.1" || web1.Port != 2222 || web1.User != "admin" {
+		t.Fatalf("web1 = %#v", web1)
+	}
+	if want := []string{"all", "prod"}; !reflect.DeepEqual(web1.Groups, want) {
+		t.Fatalf("web1 groups = %#v, want %#v", web1.Groups, want)
+	}
+
+	if got, want := inventoryAllowedIPs(t, &models.Config{}, models.Inventory{Path: path}), []string{"1.1.1.1/32", "3.3.3.3/32"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("AllowedIPs() = %#v, want %#v", got, want)
+	}
+}
+
+func TestReadInventory_ScriptWithoutMeta(t *testing.T) {
+	path := writeScript(t, `case "$1" in
+--list) echo '{"web": ["web1"]}' ;;
+--host) echo '{"ansible_host": "1.1.1.1"}' ;;
+esac
+`)
+	inv, err := readInventory(&models.Config{}, models.Inventory{Path: path, Type: formatScript})
+	if err != nil {
+		t.Fatalf("readInventory() error = %v", err)
+	}
+	if host := inv.Hosts["web1"]; host == nil || host.Host != "1.1.1.1" {
+		t.Fatalf("web1 = %#v, want address from --host", host)
+	}
+}
+
+func TestReadInventory_ScriptErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		timeout time.Duration
+		want    string
+	}{
+		{name: "failure", script: "echo 'cannot reach API' >&2; exit 2", want: "cannot reach API"},
+		{name: "timeout", script: "exec sleep 5", timeout: 100 * time.Millisecond, want: "timed out"},
+		{name: "invalid json", script: "echo 'not json'", want: "cannot parse"},
+		{name: "invalid group", script: `echo '{"web": 1}'`, want: "group web"},
+		{name: "invalid meta", script: `echo '{"_meta": []}'`, want: "_meta"},
+		{name: "invalid host vars", script: `[ "$1" = "--list" ] && echo '{"web": ["web1"]}' || echo '[]'`, want: "host web1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeScript(t, tt.script)
+			_, err := readInventory(&models.Config{InventoryTimeout: tt.timeout}, models.Inventory{Path: path})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("readInventory() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadInventory_UnknownType(t *testing.T) {
+	path := writeInventory(t, "hosts", "")
+	if _, err := readInventory(&models.Config{}, models.Inventory{Path: path, Type: "toml"}); err == nil {
+		t.Fatalf("readInventory() expected error for unknown type")
+	}
+}
+
+func TestDetectInventoryFormat_NotExecutable(t *testing.T) {
+	path := writeInventory(t, "hosts", "#!/bin/sh\n")
+	if got, _ := detectInventoryFormat(path); got != formatINI { //nolint:errcheck // tested elsewhere
+		t.Fatalf("detectInventoryFormat() = %q, want %q for non-executable file", got, formatINI)
+	}
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/etkecc/inventory-wg-sync/internal/models"
+)
+
+const testYAMLInventory = `
+all:
+  vars:
+    ansible_user: admin
+  hosts:
+    host1:
+      ansible_host: 1.2.3.4
+    nohost: {}
+  children:
+    prod:
+      vars:
+        ansible_port: 2222
+      hosts:
+        host2:
+          ansible_host: 10.0.0.2
+      children:
+        eu:
+          hosts:
+            host3:
+              ansible_host: 10.0.0.3
+              ansible_user: root
+`
+
+func writeInventory(t *testing.T, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	return path
+}
+
+func TestDetectInventoryFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		contents string
+		want     string
+	}{
+		{name: "yml extension", file: "hosts.yml", contents: "", want: formatYAML},
+		{name: "ini extension", file: "hosts.ini", contents: "all:\n", want: formatINI},
+		{name: "tfstate extension", file: "terraform.tfstate", contents: "{\n", want: formatTFState},
+		{name: "yaml content", file: "hosts", contents: "# comment\nall:\n  hosts:\n", want: formatYAML},
+		{name: "yaml document", file: "hosts", contents: "---\nall:\n", want: formatYAML},
+		{name: "ini group", file: "hosts", contents: "[prod]\nhost1 ansible_host=1.2.3.4\n", want: formatINI},
+		{name: "ini host", file: "hosts", contents: "host1:2222 ansible_host=1.2.3.4\n", want: formatINI},
+		{name: "empty", file: "hosts", contents: "", want: formatINI},
+		{name: "tfstate content", file: "state", contents: "{\n  \"version\": 4,\n  \"resources\": []\n}\n", want: formatTFState},
+		{name: "json content", file: "hosts", contents: `{"all": {"hosts": {"web1": {"ansible_host": "1.2.3.4"}}}}`, want: formatYAML},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeInventory(t, tt.file, tt.contents)
+			got, err := detectInventoryFormat(path)
+			if err != nil {
+				t.Fatalf("detectInventoryFormat() error = %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("detectInventoryFormat() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectInventoryFormat_MissingFile(t *testing.T) {
+	if _, err := detectInventoryFormat(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatalf("detectInventoryFormat() expected error for missing file")
+	}
+}
+
+func TestDetectInventoryFormat_InvalidJSON(t *testing.T) {
+	if _, err := detectInventoryFormat(writeInventory(t, "state", `{"version": 4, "resources": [`)); err == nil {
+		t.Fatalf("detectInventoryFormat() expected error for invalid JSON")
+	}
+}
+
+func TestReadInventory_YAML(t *testing.T) {
+	path := writeInventory(t, "hosts", testYAMLInventory)
+	inv, err := readInventory(&models.Config{}, models.Inventory{Path: path})
+	if err != nil {
+		t.Fatalf("readInventory() error = %v", err)
+	}
+	if len(inv.Hosts) != 4 {
+		t.Fatalf("readInventory() hosts = %#v, want 4 hosts", inv.Hosts)
+	}
+	if inv.Hosts["nohost"].Host != "" {
+		t.Fatalf("nohost = %#v, want empty address", inv.Hosts["nohost"])
+	}
+
+	host1 := inv.Hosts["host1"]
+	if host1.Host != "1.2.3.4" || host1.User != "admin" || host1.Port != 22 || host1.Group != groupUngrouped {
+		t.Fatalf("host1 = %#v", host1)
+	}
+
+	host3 := inv.Hosts["host3"]
+	if host3.Host != "10.0.0.3" || host3.User != "root" || host3.Port != 2222 || host3.Group != "eu" {
+		t.Fatalf("host3 = %#v", host3)
+	}
+	if want := []string{"all", "eu", "prod"}; !reflect.DeepEqual(host3.Groups, want) {
+		t.Fatalf("host3 groups = %#v, want %#v", host3.Groups, want)
+	}
+	if len(inv.Groups["prod"]) != 2 {
+		t.Fatalf("prod group hosts = %#v, want 2 hosts", inv.Groups["prod"])
+	}
+	if !reflect.DeepEqual(inv.GroupTree["prod"], []string{"eu"}) {
+		t.Fatalf("prod group children = %#v", inv.GroupTree["prod"])
+	}
+}
+
+func TestReadInventory_YAMLHostInSeveralGroups(t *testing.T) {
+	path := writeInventory(t, "hosts.yaml", `
+web:
+  hosts:
+    host1:
+      ansible_host: 1.2.3.4
+db:
+  vars:
+    ansible_host: 5.6.7.8
+  hosts:
+    host1:
+`)
+	inv, err := readInventory(&models.Config{}, models.Inventory{Path: path})
+	if err != nil {
+		t.Fatalf("readInventory() error = %v", err)
+	}
+	host := inv.Hosts["host1"]
+	if host == nil || host.Host != "1.2.3.4" {
+		t.Fatalf("host1 = %#v, want ansible_host from host vars", host)
+	}
+	if want := []string{"all", "db", "web"}; !reflect.DeepEqual(host.Groups, want) {
+		t.Fatalf("host1 groups = %#v, want %#v", host.Groups, want)
+	}
+}
+
+func TestReadInventory_InvalidYAML(t *testing.T) {
+	path := writeInventory(t, "hosts.yml", "all: [")
+	if _, err := readInventory(&models.Config{}, models.Inventory{Path: path}); err == nil {
+		t.Fatalf("readInventory() expected error for invalid YAML")
+	}
+}
+
+func TestAllowedIPs_InventoryYAML(t *testing.T) {
+	path := writeInventory(t, "inventory.yml", testYAMLInventory)
+	got := inventoryAllowedIPs(t, &models.Config{ExcludedIPs: []string{"10.0.0.2"}}, models.Inventory{Path: path})
+	if want := []string{"1.2.3.4/32", "10.0.0.3/32"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("AllowedIPs() = %#v, want %#v", got, want)
+	}
+}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	formatTFState = "tfstate"
+
+	tfstateVersion = 4
+)
+
+// defaultTFStateAttributes are the address attributes of the common compute resources, used if the inventory has no attributes
+var defaultTFStateAttributes = []string{
+	"hcloud_server.ipv4_address",
+	"hcloud_server.ipv6_address",
+	"aws_instance.public_ip",
+	"aws_instance.ipv6_addresses",
+	"digitalocean_droplet.ipv4_address",
+	"digitalocean_droplet.ipv6_address",
+	"google_compute_instance.network_interface.*.access_config.*.nat_ip",
+	"azurerm_public_ip.ip_address",
+}
+
+// tfstate is the Terraform state (v4 format)
+type tfstate struct {
+	Version   int               `json:"version"`
+	Resources []tfstateResource `json:"resources"`
+}
+
+// tfstateResource is a resource of the Terraform state, with an instance per count or for_each key
+type tfstateResource struct {
+	Module    string `json:"module"`
+	Mode      string `json:"mode"`
+	Type      string `json:"type"`
+	Name      string `json:"name"`
+	Instances []struct {
+		IndexKey   any            `json:"index_key"`
+		Attributes map[string]any `json:"attributes"`
+	} `json:"instances"`
+}
+
+// readTFStateTree reads the Terraform state, turning the instances of the resources with the address attributes into hosts.
+// Each attribute is "resource_type.attribute", where the attribute may be a path to a nested value, e.g.
+// "google_compute_instance.network_interface.0.access_config.*.nat_ip" ("*" means all list items).
+// Hosts are named by the resource addresses and grouped by the resource types, the first address is their ansible_host,
+// and the rest are wg_sync_extra_cidrs
+func readTFStateTree(path string, attributes []string) (*inventoryTree, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var state tfstate
+	if err := json.Unmarshal(contents, &state); err != nil {
+		return nil, fmt.Errorf("cannot parse Terraform state: %w", err)
+	}
+	if state.Version != tfstateVersion {
+		return nil, fmt.Errorf("unsupported Terraform state version %d, must be %d", state.Version, tfstateVersion)
+	}
+	if len(attributes) == 0 {
+		attributes = defaultTFStateAttributes
+	}
+	paths := tfstateAttributePaths(attributes)
+
+	tree := newInventoryTree()
+	for _, resource := range state.Resources {
+		if _, ok := paths[resource.Type]; !ok {
+			continue
+		}
+		tree.addGroup(resource.Type, "", nil)
+		for _, instance := range resource.Instances {
+			vars := maps.Clone(instance.Attributes)
+			if vars == nil {
+				vars = map[string]any{}
+			}
+			addresses := []string{}
+			for _, attrPath := range paths[resource.Type] {
+				addresses = append(addresses, varStrings(tfstateValue(instance.Attributes, attrPath))...)
+			}
+			if len(addresses) > 0 {
+				vars["ansible_host"] = addresses[0]
+				vars[extraCIDRsVar] = addresses[1:]
+			}
+			tree.addHost(resource.address(instance.IndexKey), resource.Type, vars)
+		}
+	}
+	return tree, nil
+}
+
+// tfstateAttributePaths groups the attribute paths by the resource types
+func tfstateAttributePaths(attributes []string) map[string][][]string {
+	paths := map[string][][]string{}
+	for _, attribute := range attributes {
+		resourceType, attrPath, ok := strings.Cut(attribute, ".")
+		if !ok || attrPath == "" {
+			continue
+		}
+		paths[resourceType] = append(paths[resourceType], strings.Split(attrPath, "."))
+	}
+	return paths
+}
+
+// tfstateValue returns the value of the attribute path, the "*" item of the path collects the values of all list items
+func tfstateValue(value any, attrPath []string) any {
+	if len(attrPath) == 0 {
+		return value
+	}
+	switch v := value.(type) {
+	case map[string]any:
+		return tfstateValue(v[attrPath[0]], attrPath[1:])
+	case []any:
+		if attrPath[0] == "*" {
+			values := []any{}
+			for _, item := range v {
+				values = append(values, tfstateValue(item, attrPath[1:]))
+			}
+			return values
+		}
+		if i, err := strconv.Atoi(attrPath[0]); err == nil && i >= 0 && i < len(v) {
+			return tfstateValue(v[i], attrPath[1:])
+		}
+	}
+	return nil
+}
+
+// address returns the Terraform address of the resource instance, e.g. module.web.hcloud_server.web["eu"]
+func (r tfstateResource) address(indexKey any) string {
+	parts := []string{}
+	if r.Module != "" {
+		parts = append(parts, r.Module)
+	}
+	if r.Mode == "data" {
+		parts = append(parts, "data")
+	}
+	address := strings.Join(append(parts, r.Type, r.Name), ".")
+	switch key := indexKey.(type) {
+	case string:
+		address += "[" + strconv.Quote(key) + "]"
+	case float64:
+		address += "[" + strconv.FormatFloat(key, 'f', -1, 64) + "]"
+	}
+	return address
+}
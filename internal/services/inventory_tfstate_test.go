@@ -0,0 +1,122 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/etkecc/inventory-wg-sync/internal/models"
+)
+
+const testTFState = `{
+  "version": 4,
+  "terraform_version": "1.9.0",
+  "resources": [
+    {
+      "mode": "managed",
+      "type": "hcloud_server",
+      "name": "web",
+      "instances": [
+        {"index_key": 0, "attributes": {"name": "web-0", "ipv4_address": "1.2.3.4", "ipv6_address": "2a01:4f8::1"}},
+        {"index_key": 1, "attributes": {"name": "web-1", "ipv4_address": "1.2.3.5", "ipv6_address": ""}}
+      ]
+    },
+    {
+      "module": "module.eu",
+      "mode": "managed",
+      "type": "aws_instance",
+      "name": "db",
+      "instances": [
+        {"index_key": "primary", "attributes": {"public_ip": "5.6.7.8", "ipv6_addresses": ["2600:1f18::1", "2600:1f18::2"]}}
+      ]
+    },
+    {
+      "mode": "managed",
+      "type": "google_compute_instance",
+      "name": "vm",
+      "instances": [
+        {"attributes": {"network_interface": [{"access_config": [{"nat_ip": "9.9.9.9"}]}]}}
+      ]
+    },
+    {
+      "mode": "managed",
+      "type": "hcloud_ssh_key",
+      "name": "admin",
+      "instances": [{"attributes": {"public_key": "ssh-ed25519 AAAA"}}]
+    }
+  ]
+}`
+
+func TestReadTFStateInventory(t *testing.T) {
+	path := writeInventory(t, "terraform.tfstate", testTFState)
+	inv, err := readInventory(&models.Config{}, models.Inventory{Path: path})
+	if err != nil {
+		t.Fatalf("readInventory() error = %v", err)
+	}
+
+	got := map[string][]string{}
+	for name, host := range inv.Hosts {
+		got[name] = hostAddresses(&models.Config{}, host)
+	}
+	want := map[string][]string{
+		"hcloud_server.web[0]":                 {"1.2.3.4", "2a01:4f8::1"},
+		"hcloud_server.web[1]":                 {"1.2.3.5"},
+		`module.eu.aws_instance.db["primary"]`: {"5.6.7.8", "2600:1f18::1", "2600:1f18::2"},
+		"google_compute_instance.vm":           {"9.9.9.9"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("readInventory() hosts = %#v, want %#v", got, want)
+	}
+	if host := inv.Hosts["hcloud_server.web[0]"]; host.Group != "hcloud_server" || host.Vars.String("name") != "web-0" {
+		t.Fatalf("hcloud_server.web[0] = %#v, want hcloud_server group and attributes as vars", host)
+	}
+}
+
+func TestReadTFStateInventory_Attributes(t *testing.T) {
+	path := writeInventory(t, "state.json", testTFState)
+	src := models.Inventory{Path: path, Type: formatTFState, Attributes: []string{"hcloud_server.ipv6_address", "invalid"}}
+	if got, want := inventoryAllowedIPs(t, &models.Config{}, src), []string{"2a01:4f8::1/128"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("AllowedIPs() = %#v, want %#v", got, want)
+	}
+}
+
+func TestReadTFStateInventory_RemoteBackend(t *testing.T) {
+	server := testServer(t, map[string]string{"/state/prod": testTFState})
+	inv, err := readInventory(&models.Config{}, models.Inventory{Path: server.URL + "/state/prod"})
+	if err != nil {
+		t.Fatalf("readInventory() error = %v", err)
+	}
+	if host := inv.Hosts["hcloud_server.web[0]"]; host == nil || host.Host != "1.2.3.4" {
+		t.Fatalf("hcloud_server.web[0] = %#v, want host from the remote state", host)
+	}
+}
+
+func TestReadTFStateInventory_Invalid(t *testing.T) {
+	for name, contents := range map[string]string{
+		"invalid.tfstate": "{",
+		"v3.tfstate":      `{"version": 3, "modules": []}`,
+	} {
+		path := writeInventory(t, name, contents)
+		if _, err := readInventory(&models.Config{}, models.Inventory{Path: path}); err == nil {
+			t.Fatalf("readInventory(%s) expected error", name)
+		}
+	}
+}
+
+func TestTFStateValue(t *testing.T) {
+	attributes := map[string]any{"interfaces": []any{map[string]any{"ip": "10.0.0.1"}, map[string]any{"ip": "10.0.0.2"}}}
+	tests := []struct {
+		path []string
+		want []string
+	}{
+		{path: []string{"interfaces", "*", "ip"}, want: []string{"10.0.0.1", "10.0.0.2"}},
+		{path: []string{"interfaces", "1", "ip"}, want: []string{"10.0.0.2"}},
+		{path: []string{"interfaces", "5", "ip"}},
+		{path: []string{"missing"}},
+	}
+
+	for _, tt := range tests {
+		if got := varStrings(tfstateValue(attributes, tt.path)); !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("tfstateValue(%v) = %#v, want %#v", tt.path, got, tt.want)
+		}
+	}
+}
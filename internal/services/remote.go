@@ -0,0 +1,81 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/etkecc/inventory-wg-sync/internal/models"
+	"github.com/etkecc/inventory-wg-sync/internal/utils"
+)
+
+// fetch downloads the http(s) URL with the http options of the config
+func fetch(cfg *models.Config, rawURL string) ([]byte, error) {
+	fetcher := &utils.Fetcher{Timeout: cfg.HTTP.Timeout, CacheDir: cfg.HTTP.CacheDir, Grace: cfg.HTTP.Grace, TokenFiles: cfg.HTTP.TokenFiles}
+	return fetcher.Fetch(rawURL)
+}
+
+// readSource reads the local file or downloads the http(s) URL
+func readSource(cfg *models.Config, source string) ([]byte, error) {
+	if utils.IsURL(source) {
+		return fetch(cfg, source)
+	}
+	return os.ReadFile(source)
+}
+
+// expandRemoteEntries replaces http(s) URL entries with the entries listed in the downloaded documents,
+// one per line with # comments. URLs that cannot be downloaded are handled according to the unresolvable policy
+func expandRemoteEntries(cfg *models.Config, entries []string, policy, source string) ([]string, error) {
+	expanded := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !utils.IsURL(entry) {
+			expanded = append(expanded, entry)
+			continue
+		}
+		contents, err := fetch(cfg, entry)
+		if err != nil {
+			utils.Log("ERROR: cannot fetch", source, "list", entry, ":", err)
+			if err := unresolvable(policy, source, entry); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		for _, line := range strings.Split(string(contents), "\n") {
+			line, _, _ = strings.Cut(line, "#")
+			if line = strings.TrimSpace(line); line != "" {
+				expanded = append(expanded, line)
+			}
+		}
+	}
+	return expanded, nil
+}
+
+// downloadInventory downloads the http(s) inventory into a private temporary dir, keeping the URL extension,
+// so it is read and detected the same way as local inventories. The file is placed into the nested inventory dir,
+// so group_vars, host_vars and ansible.cfg looked up around it cannot be planted by other users of the shared temp dir.
+// The returned func removes the dir
+func downloadInventory(cfg *models.Config, src models.Inventory) (path string, cleanup func(), err error) {
+	if src.Type == formatScript {
+		return "", nil, errors.New("inventory script cannot be an URL")
+	}
+	contents, err := fetch(cfg, src.Path)
+	if err != nil {
+		return "", nil, err
+	}
+	dir, err := os.MkdirTemp("", "inventory-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup = func() { os.RemoveAll(dir) }
+	path = filepath.Join(dir, "inventory", "hosts"+utils.URLExt(src.Path))
+	if err := os.Mkdir(filepath.Dir(path), 0o700); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	if err := os.WriteFile(path, contents, 0o600); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return path, cleanup, nil
+}
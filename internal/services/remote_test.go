@@ -0,0 +1,101 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/etkecc/inventory-wg-sync/internal/models"
+)
+
+func testServer(t *testing.T, files map[string]string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contents, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(contents)) //nolint:errcheck // test server
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestExpandRemoteEntries(t *testing.T) {
+	server := testServer(t, map[string]string{"/exclusions.txt": "# shared exclusions\n10.10.0.0/16\n\nvpn.example.com # gateway\n"})
+	cfg := &models.Config{}
+
+	got, err := expandRemoteEntries(cfg, []string{"10.0.0.1", server.URL + "/exclusions.txt", server.URL + "/missing.txt"}, "", sourceExcludedIPs)
+	if err != nil {
+		t.Fatalf("expandRemoteEntries() error = %v", err)
+	}
+	if want := []string{"10.0.0.1", "10.10.0.0/16", "vpn.example.com"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expandRemoteEntries() = %#v, want %#v", got, want)
+	}
+
+	if _, err := expandRemoteEntries(cfg, []string{server.URL + "/missing.txt"}, models.UnresolvableFail, sourceExcludedIPs); err == nil {
+		t.Fatalf("expandRemoteEntries() expected error with the fail policy")
+	}
+}
+
+func TestAllowedIPs_Remote(t *testing.T) {
+	server := testServer(t, map[string]string{
+		"/exclusions.txt": "10.10.0.0/16\n",
+		"/hosts.yml":      "all:\n  hosts:\n    web1:\n      ansible_host: 10.10.0.1\n    web2:\n      ansible_host: 10.20.0.1\n",
+	})
+	cfg := &models.Config{
+		InventoryPaths: []string{server.URL + "/hosts.yml"},
+		ExcludedIPs:    []string{server.URL + "/exclusions.txt"},
+		HTTP:           models.HTTP{CacheDir: t.TempDir()},
+	}
+	got, err := AllowedIPs(cfg)
+	if err != nil {
+		t.Fatalf("AllowedIPs() error = %v", err)
+	}
+	if want := []string{"10.20.0.1/32"}; !reflect.DeepEqual(prefixStrings(got.AllowedIPs), want) {
+		t.Fatalf("AllowedIPs() = %#v, want %#v", prefixStrings(got.AllowedIPs), want)
+	}
+
+	// the cached copies are used when the server is unreachable
+	server.Close()
+	got, err = AllowedIPs(cfg)
+	if err != nil {
+		t.Fatalf("AllowedIPs() error = %v", err)
+	}
+	if want := []string{"10.20.0.1/32"}; !reflect.DeepEqual(prefixStrings(got.AllowedIPs), want) {
+		t.Fatalf("AllowedIPs() = %#v, want %#v from the cache", prefixStrings(got.AllowedIPs), want)
+	}
+}
+
+func TestReadInventory_RemoteScript(t *testing.T) {
+	server := testServer(t, map[string]string{"/inventory.py": "#!/bin/sh\n"})
+	if _, err := readInventory(&models.Config{}, models.Inventory{Path: server.URL + "/inventory.py", Type: formatScript}); err == nil {
+		t.Fatalf("readInventory() expected error for remote script")
+	}
+}
+
+func TestReadInventory_RemoteIgnoresSharedTempDir(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	writeFiles(t, tmp, map[string]string{
+		"group_vars/all.yml": "wg_sync_extra_cidrs: [6.6.6.0/24]\n",
+		"host_vars/web1.yml": "ansible_host: 6.6.6.6\n",
+		"ansible.cfg":        "[defaults]\ninventory = " + filepath.Join(tmp, "planted") + "\n",
+		"planted":            "evil ansible_host=6.6.6.7\n",
+	})
+	server := testServer(t, map[string]string{"/hosts": "web1 ansible_host=1.1.1.1\n"})
+
+	inv, err := readInventory(&models.Config{AnsibleInventory: true}, models.Inventory{Path: server.URL + "/hosts"})
+	if err != nil {
+		t.Fatalf("readInventory() error = %v", err)
+	}
+	if len(inv.Hosts) != 1 || inv.Hosts["web1"] == nil {
+		t.Fatalf("readInventory() hosts = %#v, want web1 only", inv.Hosts)
+	}
+	if web1 := inv.Hosts["web1"]; web1.Host != "1.1.1.1" || web1.Vars[extraCIDRsVar] != nil {
+		t.Fatalf("web1 = %#v, want no vars from the shared temp dir", web1)
+	}
+}
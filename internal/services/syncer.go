@@ -6,14 +6,17 @@ import (
 )
 
 func Sync(cfg *models.Config) error {
-	allowedIPs := AllowedIPs(cfg)
-	utils.Log("discovered", len(allowedIPs), "allowed IPs")
-	if len(allowedIPs) == 0 {
+	res, err := AllowedIPs(cfg)
+	if err != nil {
+		return err
+	}
+	utils.Log("discovered", len(res.AllowedIPs), "allowed IPs from", res.Hosts, "hosts,", res.Skipped, "hosts skipped,", res.TODOs, "hosts with TODOs")
+	if len(res.AllowedIPs) == 0 {
 		utils.Log("WARNING: no allowed IPs found")
 	}
-	if len(allowedIPs) == 0 {
+	if len(res.AllowedIPs) == 0 {
 		return nil
 	}
 
-	return SyncWireGuard(cfg, allowedIPs)
+	return SyncWireGuard(cfg, res.AllowedIPs, res.Sources)
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"net"
+	"net/netip"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -22,7 +23,9 @@ var (
 	runSystemctlFunc   = runSystemctl
 )
 
-func SyncWireGuard(cfg *models.Config, allowedIPs []string) error {
+// SyncWireGuard updates the WireGuard profile with allowed IPs and restarts the interface.
+// Sources are used to report the allowed IPs dropped from the profile
+func SyncWireGuard(cfg *models.Config, allowedIPs []netip.Prefix, sources Sources) error {
 	if cfg.ProfilePath == "" {
 		return nil
 	}
@@ -32,7 +35,7 @@ func SyncWireGuard(cfg *models.Config, allowedIPs []string) error {
 	if !interfaceNameRegex.MatchString(name) {
 		return errors.New("wireguard interface name is invalid")
 	}
-	if err := updateWGProfile(name, allowedIPs, cfg.PostUp, cfg.PostDown, cfg.ProfilePath, cfg.Table); err != nil {
+	if err := updateWGProfile(cfg, name, allowedIPs, sources); err != nil {
 		return err
 	}
 
@@ -49,21 +52,25 @@ func SyncWireGuard(cfg *models.Config, allowedIPs []string) error {
 	return restartUnit(name)
 }
 
-func updateWGProfile(name string, allowedIPs, postUp, postDown []string, path string, table int) error {
+func updateWGProfile(cfg *models.Config, name string, allowedIPs []netip.Prefix, sources Sources) error {
+	path, table, postUp, postDown := cfg.ProfilePath, cfg.Table, cfg.PostUp, cfg.PostDown
 	contents, err := os.ReadFile(path)
 	if err != nil {
 		return err
 	}
 
 	lines := strings.Split(string(contents), "\n")
-	allowedIPs = filterOutUnsupportedIPs(lines, allowedIPs)
+	allowedIPs = filterOutUnsupportedIPs(lines, allowedIPs, sources)
+	if err := checkShrinkage(cfg, currentAllowedIPs(lines), allowedIPs); err != nil {
+		return err
+	}
 
 	for i, line := range lines {
 		if strings.HasPrefix(line, "Table") && table > 0 {
 			lines[i] = "Table = " + strconv.Itoa(table)
 		}
 		if strings.HasPrefix(line, "AllowedIPs") {
-			lines[i] = "AllowedIPs = " + strings.Join(allowedIPs, ",")
+			lines[i] = "AllowedIPs = " + joinPrefixes(allowedIPs)
 		}
 		if strings.HasPrefix(line, "PostUp") && len(postUp) > 0 {
 			lines[i] = "PostUp = " + strings.Join(postUp, "; ")
@@ -81,52 +88,91 @@ func updateWGProfile(name string, allowedIPs, postUp, postDown []string, path st
 	return os.WriteFile(path, contents, 0o600)
 }
 
-// determineIPCapability tells if the WireGuard profile contains IPv4 and IPv6 addresses on the `Interface.Address` line
+// determineIPCapability tells if the WireGuard profile contains IPv4 and IPv6 addresses on the `Interface.Address` lines
 func determineIPCapability(lines []string) (ipv4, ipv6 bool) {
-	for _, line := range lines {
-		if strings.HasPrefix(line, "Address") {
-			isIPv4Capable := strings.Contains(line, ".")
-			isIPv6Capable := strings.Contains(line, ":")
-			return isIPv4Capable, isIPv6Capable
+	for _, entry := range profileValues(lines, "Address") {
+		addr, err := netip.ParseAddr(entry)
+		if prefix, perr := netip.ParsePrefix(entry); perr == nil {
+			addr, err = prefix.Addr(), nil
 		}
+		if err != nil {
+			utils.Log("WARNING: profile Address entry", entry, "is not an IP address or CIDR, ignoring it")
+			continue
+		}
+		// IPv4-mapped IPv6 addresses are IPv6 addresses
+		ipv4 = ipv4 || addr.Is4()
+		ipv6 = ipv6 || addr.Is6()
 	}
-	return false, false
+	return ipv4, ipv6
 }
 
-// filterOutUnsupportedIPs filters out IP addresses that the WireGuard profile does not support
-func filterOutUnsupportedIPs(lines, allowedIPs []string) []string {
-	ipv4, ipv6 := determineIPCapability(lines)
-	if !ipv4 {
-		allowedIPsNew := filterOutCIDRsContainingChar(allowedIPs, ".")
-		diff := len(allowedIPs) - len(allowedIPsNew)
-		if diff > 0 {
-			utils.Log("filtered out", diff, "IPv4 CIDRs due to the profile's lack of IPv4 support")
-			allowedIPs = allowedIPsNew
-		}
+// profileFamilies tells which address families the WireGuard profile supports,
+// both when there is no profile to read (the read error is reported when the profile is updated)
+func profileFamilies(cfg *models.Config) (ipv4, ipv6 bool) {
+	if cfg.ProfilePath == "" {
+		return true, true
 	}
-	if !ipv6 {
-		allowedIPsNew := filterOutCIDRsContainingChar(allowedIPs, ":")
-		diff := len(allowedIPs) - len(allowedIPsNew)
-		if diff > 0 {
-			utils.Log("filtered out", diff, "IPv6 CIDRs due to the profile's lack of IPv6 support")
-			allowedIPs = allowedIPsNew
-		}
+	contents, err := os.ReadFile(cfg.ProfilePath)
+	if err != nil {
+		return true, true
 	}
+	return determineIPCapability(strings.Split(string(contents), "\n"))
+}
 
-	return allowedIPs
+// profileValues returns comma-separated values of all lines with the key (case-insensitive), without comments
+func profileValues(lines []string, key string) []string {
+	values := []string{}
+	for _, line := range lines {
+		lineKey, value, ok := strings.Cut(line, "=")
+		if !ok || !strings.EqualFold(strings.TrimSpace(lineKey), key) {
+			continue
+		}
+		value, _, _ = strings.Cut(value, "#")
+		for _, entry := range strings.Split(value, ",") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				values = append(values, entry)
+			}
+		}
+	}
+	return values
 }
 
-// filterOutCIDRsContainingChar removes CIDRs from the allowedIPs list if they contain a given character (e.g. "." for IPv4 and ":" for IPv6)
-func filterOutCIDRsContainingChar(allowedIPs []string, needle string) []string {
-	result := make([]string, 0, len(allowedIPs))
-	for _, ip := range allowedIPs {
-		if !strings.Contains(ip, needle) {
-			result = append(result, ip)
+// filterOutUnsupportedIPs filters out IP addresses that the WireGuard profile does not support,
+// reporting each dropped CIDR with its sources
+func filterOutUnsupportedIPs(lines []string, allowedIPs []netip.Prefix, sources Sources) []netip.Prefix {
+	ipv4, ipv6 := determineIPCapability(lines)
+	result := make([]netip.Prefix, 0, len(allowedIPs))
+	var droppedIPv4, droppedIPv6 int
+	for _, prefix := range allowedIPs {
+		switch {
+		case prefix.Addr().Is4() && !ipv4:
+			droppedIPv4++
+			utils.Log("dropped", prefix, "from", strings.Join(sources.of(prefix), ", "), "due to the profile's lack of IPv4 support")
+		case prefix.Addr().Is6() && !ipv6:
+			droppedIPv6++
+			utils.Log("dropped", prefix, "from", strings.Join(sources.of(prefix), ", "), "due to the profile's lack of IPv6 support")
+		default:
+			result = append(result, prefix)
 		}
 	}
+	if droppedIPv4 > 0 {
+		utils.Log("filtered out", droppedIPv4, "IPv4 CIDRs due to the profile's lack of IPv4 support")
+	}
+	if droppedIPv6 > 0 {
+		utils.Log("filtered out", droppedIPv6, "IPv6 CIDRs due to the profile's lack of IPv6 support")
+	}
 	return result
 }
 
+// joinPrefixes returns the comma-separated list of CIDRs
+func joinPrefixes(prefixes []netip.Prefix) string {
+	cidrs := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		cidrs = append(cidrs, prefix.String())
+	}
+	return strings.Join(cidrs, ",")
+}
+
 func applyVars(tplString string, vars map[string]any) ([]byte, error) {
 	var result bytes.Buffer
 	tpl, err := template.New("template").Parse(tplString)
@@ -1,7 +1,9 @@
 package services
 
 import (
+	"bytes"
 	"errors"
+	"log"
 	"net"
 	"os"
 	"path/filepath"
@@ -10,6 +12,7 @@ import (
 	"testing"
 
 	"github.com/etkecc/inventory-wg-sync/internal/models"
+	"github.com/etkecc/inventory-wg-sync/internal/utils"
 )
 
 func TestDetermineIPCapability(t *testing.T) {
@@ -48,10 +51,10 @@ func TestUpdateWGProfile(t *testing.T) {
 		t.Fatalf("WriteFile() error = %v", err)
 	}
 
-	allowed := []string{"10.0.0.1/32", "fd00::1/128"}
+	allowed := parsePrefixes("10.0.0.1/32", "fd00::1/128")
 	postUp := []string{"echo up"}
 	postDown := []string{"echo down"}
-	if err := updateWGProfile("wg0", allowed, postUp, postDown, path, 555); err != nil {
+	if err := updateWGProfile(&models.Config{ProfilePath: path, Table: 555, PostUp: postUp, PostDown: postDown}, "wg0", allowed, nil); err != nil {
 		t.Fatalf("updateWGProfile() error = %v", err)
 	}
 
@@ -86,15 +89,6 @@ func TestUpdateWGProfile(t *testing.T) {
 	}
 }
 
-func TestFilterOutCIDRsContainingChar(t *testing.T) {
-	ips := []string{"10.0.0.1/32", "fd00::1/128", "10.0.0.2/32"}
-	got := filterOutCIDRsContainingChar(ips, ".")
-	want := []string{"fd00::1/128"}
-	if !reflect.DeepEqual(got, want) {
-		t.Fatalf("filterOutCIDRsContainingChar() = %#v, want %#v", got, want)
-	}
-}
-
 func TestDetermineIPCapability_Both(t *testing.T) {
 	ipv4, ipv6 := determineIPCapability([]string{
 		"[Interface]",
@@ -105,9 +99,52 @@ func TestDetermineIPCapability_Both(t *testing.T) {
 	}
 }
 
+func TestDetermineIPCapability_Parsed(t *testing.T) {
+	tests := []struct {
+		name     string
+		lines    []string
+		wantIPv4 bool
+		wantIPv6 bool
+	}{
+		{name: "ipv4-mapped ipv6", lines: []string{"Address = ::ffff:10.0.0.1/128"}, wantIPv4: false, wantIPv6: true},
+		{name: "comment", lines: []string{"Address = 10.0.0.1/32 # fd00::1/128"}, wantIPv4: true, wantIPv6: false},
+		{name: "multiple lines", lines: []string{"Address = 10.0.0.1/32", "address=fd00::1/64"}, wantIPv4: true, wantIPv6: true},
+		{name: "plain address", lines: []string{"Address = fd00::1"}, wantIPv4: false, wantIPv6: true},
+		{name: "dns name", lines: []string{"Address = wg.example.com, 10.0.0.1/32"}, wantIPv4: true, wantIPv6: false},
+		{name: "not an address line", lines: []string{"AddressFamily = fd00::1", "# Address = fd00::1"}, wantIPv4: false, wantIPv6: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ipv4, ipv6 := determineIPCapability(tt.lines)
+			if ipv4 != tt.wantIPv4 || ipv6 != tt.wantIPv6 {
+				t.Fatalf("determineIPCapability() = %v,%v, want %v,%v", ipv4, ipv6, tt.wantIPv4, tt.wantIPv6)
+			}
+		})
+	}
+}
+
+func TestFilterOutUnsupportedIPs_ReportsSources(t *testing.T) {
+	var buf bytes.Buffer
+	utils.SetLogger(log.New(&buf, "", 0))
+	defer utils.SetLogger(nil)
+
+	sources := Sources{}
+	sources.add("web1", parsePrefixes("fd00::1/128")...)
+	sources.add("web2", parsePrefixes("fd00::/127")...)
+	sources.add("web3", parsePrefixes("10.0.0.1/32")...)
+	got := filterOutUnsupportedIPs([]string{"Address = 10.0.0.2/32"}, parsePrefixes("10.0.0.1/32", "fd00::/127"), sources)
+	if want := []string{"10.0.0.1/32"}; !reflect.DeepEqual(prefixStrings(got), want) {
+		t.Fatalf("filterOutUnsupportedIPs() = %#v, want %#v", prefixStrings(got), want)
+	}
+	if want := "dropped fd00::/127 from web1, web2 due to the profile's lack of IPv6 support\n"; !strings.Contains(buf.String(), want) {
+		t.Fatalf("filterOutUnsupportedIPs() log = %q, want %q", buf.String(), want)
+	}
+}
+
 func TestFilterOutUnsupportedIPs_NoAddress(t *testing.T) {
-	allowed := []string{"10.0.0.1/32", "fd00::1/128"}
-	got := filterOutUnsupportedIPs([]string{"[Interface]"}, allowed)
+	allowed := parsePrefixes("10.0.0.1/32", "fd00::1/128")
+	got := filterOutUnsupportedIPs([]string{"[Interface]"}, allowed, nil)
 	if len(got) != 0 {
 		t.Fatalf("filterOutUnsupportedIPs(no-address) = %#v, want empty", got)
 	}
@@ -132,8 +169,8 @@ func TestUpdateWGProfile_NoTableNoPost(t *testing.T) {
 		t.Fatalf("WriteFile() error = %v", err)
 	}
 
-	allowed := []string{"10.0.0.1/32"}
-	if err := updateWGProfile("wg0", allowed, nil, nil, path, 0); err != nil {
+	allowed := parsePrefixes("10.0.0.1/32")
+	if err := updateWGProfile(&models.Config{ProfilePath: path}, "wg0", allowed, nil); err != nil {
 		t.Fatalf("updateWGProfile() error = %v", err)
 	}
 
@@ -169,7 +206,7 @@ func TestUpdateWGProfile_InvalidTemplate(t *testing.T) {
 	if err := os.WriteFile(path, []byte(initial), 0o600); err != nil {
 		t.Fatalf("WriteFile() error = %v", err)
 	}
-	if err := updateWGProfile("wg0", []string{"10.0.0.1/32"}, nil, nil, path, 0); err == nil {
+	if err := updateWGProfile(&models.Config{ProfilePath: path}, "wg0", parsePrefixes("10.0.0.1/32"), nil); err == nil {
 		t.Fatalf("updateWGProfile() expected error for invalid template")
 	}
 }
@@ -188,7 +225,7 @@ func TestRunSystemctl_PathMissing(t *testing.T) {
 }
 
 func TestUpdateWGProfile_ReadFileError(t *testing.T) {
-	if err := updateWGProfile("wg0", []string{"10.0.0.1/32"}, nil, nil, filepath.Join(t.TempDir(), "missing.conf"), 0); err == nil {
+	if err := updateWGProfile(&models.Config{ProfilePath: filepath.Join(t.TempDir(), "missing.conf")}, "wg0", parsePrefixes("10.0.0.1/32"), nil); err == nil {
 		t.Fatalf("updateWGProfile() expected error for missing file")
 	}
 }
@@ -201,14 +238,14 @@ func TestApplyVars_ExecuteError(t *testing.T) {
 
 func TestSyncWireGuard_ProfileReadError(t *testing.T) {
 	cfg := &models.Config{ProfilePath: filepath.Join(t.TempDir(), "wg0.conf")}
-	if err := SyncWireGuard(cfg, []string{"10.0.0.1/32"}); err == nil {
+	if err := SyncWireGuard(cfg, parsePrefixes("10.0.0.1/32"), nil); err == nil {
 		t.Fatalf("SyncWireGuard() expected error for missing profile")
 	}
 }
 
 func TestSyncWireGuard_NoProfile(t *testing.T) {
 	cfg := &models.Config{}
-	if err := SyncWireGuard(cfg, []string{"10.0.0.1/32"}); err != nil {
+	if err := SyncWireGuard(cfg, parsePrefixes("10.0.0.1/32"), nil); err != nil {
 		t.Fatalf("SyncWireGuard() error = %v", err)
 	}
 }
@@ -220,7 +257,7 @@ func TestSyncWireGuard_InvalidInterfaceName(t *testing.T) {
 		t.Fatalf("WriteFile() error = %v", err)
 	}
 	cfg := &models.Config{ProfilePath: path}
-	if err := SyncWireGuard(cfg, []string{"10.0.0.1/32"}); err == nil {
+	if err := SyncWireGuard(cfg, parsePrefixes("10.0.0.1/32"), nil); err == nil {
 		t.Fatalf("SyncWireGuard() expected error for invalid interface name")
 	}
 }
@@ -249,7 +286,7 @@ func TestSyncWireGuard_StartsWhenInterfaceMissing(t *testing.T) {
 	}
 
 	cfg := &models.Config{ProfilePath: path}
-	if err := SyncWireGuard(cfg, []string{"10.0.0.1/32"}); err != nil {
+	if err := SyncWireGuard(cfg, parsePrefixes("10.0.0.1/32"), nil); err != nil {
 		t.Fatalf("SyncWireGuard() error = %v", err)
 	}
 	if gotAction != "start" {
@@ -281,10 +318,35 @@ func TestSyncWireGuard_RestartsWhenInterfaceExists(t *testing.T) {
 	}
 
 	cfg := &models.Config{ProfilePath: path}
-	if err := SyncWireGuard(cfg, []string{"10.0.0.1/32"}); err != nil {
+	if err := SyncWireGuard(cfg, parsePrefixes("10.0.0.1/32"), nil); err != nil {
 		t.Fatalf("SyncWireGuard() error = %v", err)
 	}
 	if gotAction != "restart" {
 		t.Fatalf("systemctl action = %q, want %q", gotAction, "restart")
 	}
 }
+
+func TestUpdateWGProfile_ShrinkageRefused(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wg0.conf")
+	initial := "[Interface]\nAddress = 10.0.0.1/32\n\n[Peer]\nAllowedIPs = 10.0.0.1/32,10.0.0.2/32,10.0.0.3/32,10.0.0.4/32\n"
+	if err := os.WriteFile(path, []byte(initial), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	cfg := &models.Config{ProfilePath: path, MaxRemovedPercent: 50}
+	if err := updateWGProfile(cfg, "wg0", parsePrefixes("10.0.0.1/32"), nil); err == nil {
+		t.Fatalf("updateWGProfile() expected error when too many AllowedIPs are removed")
+	}
+	gotb, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if string(gotb) != initial {
+		t.Fatalf("profile was changed despite the refusal: %q", gotb)
+	}
+
+	cfg.Force = true
+	if err := updateWGProfile(cfg, "wg0", parsePrefixes("10.0.0.1/32"), nil); err != nil {
+		t.Fatalf("updateWGProfile() with force error = %v", err)
+	}
+}
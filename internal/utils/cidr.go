@@ -0,0 +1,359 @@
+package utils
+
+import (
+	"container/heap"
+	"math"
+	"math/big"
+	"net/netip"
+	"slices"
+	"sort"
+)
+
+// ipRange is an inclusive range of addresses of the same family
+type ipRange struct {
+	from netip.Addr
+	to   netip.Addr
+}
+
+// coarseNode is a node of the binary tree of sorted non-overlapping prefixes: either a prefix (leaf),
+// or the common supernet of its subtrees, which is the only one coarsening step covering both of them
+type coarseNode struct {
+	prefix      netip.Prefix
+	parent      *coarseNode
+	left, right *coarseNode // nil for the leaves
+	boundary    netip.Addr  // first address of the right half of the supernet, orders the nodes as their adjacent prefixes
+	count       int         // number of prefixes under the node
+	cost        float64     // (approximate) number of addresses added by replacing the prefixes under the node with its supernet
+	index       int         // index in the heap, -1 if the node is not a merge candidate
+}
+
+// coarseHeap is the min-heap of the merge candidates by their cost, then by their position
+type coarseHeap []*coarseNode
+
+// SummarizeCIDRs returns the minimal sorted set of CIDRs covering the same addresses:
+// CIDRs contained in broader ones are removed, and adjacent CIDRs are merged
+func SummarizeCIDRs(prefixes []netip.Prefix) []netip.Prefix {
+	ranges := make([]ipRange, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		ranges = append(ranges, prefixRange(prefix))
+	}
+
+	result := make([]netip.Prefix, 0, len(prefixes))
+	for _, r := range mergeRanges(ranges) {
+		result = append(result, rangePrefixes(r)...)
+	}
+	SortPrefixes(result)
+	return result
+}
+
+// prefixRange returns the first and the last address of the prefix
+func prefixRange(prefix netip.Prefix) ipRange {
+	prefix = prefix.Masked()
+	return ipRange{from: prefix.Addr(), to: lastAddr(prefix)}
+}
+
+// lastAddr returns the last address of the masked prefix
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	bytes := prefix.Addr().AsSlice()
+	for bit := prefix.Bits(); bit < len(bytes)*8; bit++ {
+		bytes[bit/8] |= 0x80 >> (bit % 8)
+	}
+	addr, _ := netip.AddrFromSlice(bytes)
+	return addr
+}
+
+// mergeRanges sorts the ranges and merges overlapping and adjacent ones of the same family
+func mergeRanges(ranges []ipRange) []ipRange {
+	slices.SortFunc(ranges, func(a, b ipRange) int {
+		return a.from.Compare(b.from)
+	})
+	merged := []ipRange{}
+	for _, r := range ranges {
+		if len(merged) > 0 {
+			last := &merged[len(merged)-1]
+			if last.from.BitLen() == r.from.BitLen() && !rangesApart(*last, r) {
+				if r.to.Compare(last.to) > 0 {
+					last.to = r.to
+				}
+				continue
+			}
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// rangesApart tells if there is a gap between the ranges, b must not start before a
+func rangesApart(a, b ipRange) bool {
+	next := a.to.Next()
+	return next.IsValid() && next.Compare(b.from) < 0
+}
+
+// rangePrefixes splits the range into the minimal list of prefixes
+func rangePrefixes(r ipRange) []netip.Prefix {
+	prefixes := []netip.Prefix{}
+	from := r.from
+	for from.IsValid() && from.Compare(r.to) <= 0 {
+		prefix := largestPrefix(from, r.to)
+		prefixes = append(prefixes, prefix)
+		from = lastAddr(prefix).Next()
+	}
+	return prefixes
+}
+
+// largestPrefix returns the largest prefix starting at the address and not going beyond the last address
+func largestPrefix(from, to netip.Addr) netip.Prefix {
+	for bits := 0; bits < from.BitLen(); bits++ {
+		prefix := netip.PrefixFrom(from, bits)
+		if prefix.Masked().Addr() == from && lastAddr(prefix).Compare(to) <= 0 {
+			return prefix
+		}
+	}
+	return netip.PrefixFrom(from, from.BitLen())
+}
+
+// SubtractCIDRs removes the excluded CIDRs from the CIDRs. A CIDR overlapping with excluded ones is split into
+// the minimal list of CIDRs covering the rest of its addresses, other CIDRs are kept as-is
+func SubtractCIDRs(prefixes, excluded []netip.Prefix) []netip.Prefix {
+	exRanges := make([]ipRange, 0, len(excluded))
+	for _, prefix := range excluded {
+		exRanges = append(exRanges, prefixRange(prefix))
+	}
+	exRanges = mergeRanges(exRanges)
+	if len(exRanges) == 0 {
+		return prefixes
+	}
+
+	result := make([]netip.Prefix, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		r := prefixRange(prefix)
+		rest := subtractRanges(r, exRanges)
+		if len(rest) == 1 && rest[0] == r {
+			result = append(result, prefix)
+			continue
+		}
+		for _, restRange := range rest {
+			result = append(result, rangePrefixes(restRange)...)
+		}
+	}
+	return result
+}
+
+// subtractRanges returns parts of the range not covered by the excluded ranges, which must be merged and sorted
+func subtractRanges(r ipRange, excluded []ipRange) []ipRange {
+	rest := []ipRange{}
+	from := r.from
+	for _, ex := range excluded {
+		if ex.from.BitLen() != r.from.BitLen() || ex.to.Compare(from) < 0 {
+			continue
+		}
+		if ex.from.Compare(r.to) > 0 {
+			break
+		}
+		if ex.from.Compare(from) > 0 {
+			rest = append(rest, ipRange{from: from, to: ex.from.Prev()})
+		}
+		from = ex.to.Next()
+		if !from.IsValid() || from.Compare(r.to) > 0 {
+			return rest
+		}
+	}
+	return append(rest, ipRange{from: from, to: r.to})
+}
+
+// CoarsenCIDRs widens CIDRs until there are no more than limit of them, each time choosing the merge
+// that adds the least address space. CIDRs are never widened to prefixes shorter than minBits4 (IPv4)
+// or minBits6 (IPv6), or to prefixes overlapping with the excluded CIDRs.
+// It returns the coarsened CIDRs, and false if they still don't fit into the limit
+func CoarsenCIDRs(prefixes, excluded []netip.Prefix, limit, minBits4, minBits6 int) ([]netip.Prefix, bool) {
+	result := SummarizeCIDRs(prefixes)
+	if len(result) <= limit {
+		return result, true
+	}
+	exRanges := make([]ipRange, 0, len(excluded))
+	for _, prefix := range excluded {
+		exRanges = append(exRanges, prefixRange(prefix))
+	}
+	exRanges = mergeRanges(exRanges)
+
+	roots := coarseTree(result)
+	candidates := coarseHeap{}
+	for _, root := range roots {
+		root.walk(func(node *coarseNode) {
+			minBits := minBits4
+			if node.prefix.Addr().Is6() {
+				minBits = minBits6
+			}
+			if node.left != nil && node.prefix.Bits() >= minBits && !overlapsRanges(prefixRange(node.prefix), exRanges) {
+				node.index = len(candidates)
+				candidates = append(candidates, node)
+			}
+		})
+	}
+	heap.Init(&candidates)
+
+	count := len(result)
+	for count > limit && candidates.Len() > 0 {
+		count -= candidates.merge(candidates[0])
+	}
+
+	result = result[:0]
+	for _, root := range roots {
+		root.walk(func(node *coarseNode) {
+			if node.left == nil {
+				result = append(result, node.prefix)
+			}
+		})
+	}
+	return result, count <= limit
+}
+
+// coarseTree builds the trees (one per address family) of the sorted non-overlapping prefixes
+func coarseTree(prefixes []netip.Prefix) []*coarseNode {
+	roots := []*coarseNode{}
+	spine := []*coarseNode{} // the path from the root to the last leaf
+	for _, prefix := range prefixes {
+		leaf := &coarseNode{prefix: prefix, count: 1, index: -1}
+		if len(spine) > 0 && spine[0].prefix.Addr().BitLen() != prefix.Addr().BitLen() {
+			roots = append(roots, spine[0])
+			spine = spine[:0]
+		}
+		if len(spine) == 0 {
+			spine = append(spine, leaf)
+			continue
+		}
+
+		// the subtree of the previous prefixes not containing this one becomes the left subtree of their common supernet
+		var left *coarseNode
+		for len(spine) > 0 && !spine[len(spine)-1].prefix.Contains(prefix.Addr()) {
+			left = spine[len(spine)-1]
+			spine = spine[:len(spine)-1]
+		}
+		supernet, _ := commonSupernet(left.prefix, prefix)
+		node := &coarseNode{prefix: supernet, left: left, right: leaf, boundary: halfAddr(supernet), index: -1}
+		left.parent, leaf.parent = node, node
+		if len(spine) > 0 {
+			node.parent = spine[len(spine)-1]
+			node.parent.right = node
+		}
+		spine = append(spine, node, leaf)
+	}
+	if len(spine) > 0 {
+		roots = append(roots, spine[0])
+	}
+
+	for _, root := range roots {
+		root.walk(func(node *coarseNode) {
+			if node.left != nil {
+				node.count = node.left.count + node.right.count
+				node.cost = prefixSize(node.prefix) - (prefixSize(node.left.prefix) - node.left.cost) - (prefixSize(node.right.prefix) - node.right.cost)
+			}
+		})
+	}
+	return roots
+}
+
+// walk calls fn for the node subtree in post-order, so the leaves are visited in the prefixes order
+func (n *coarseNode) walk(fn func(*coarseNode)) {
+	if n.left != nil {
+		n.left.walk(fn)
+		n.right.walk(fn)
+	}
+	fn(n)
+}
+
+// merge replaces the prefixes under the node with its supernet, updating the ancestors and removing the descendants from the heap.
+// It returns the number of the removed prefixes
+func (h *coarseHeap) merge(node *coarseNode) int {
+	removed := node.count - 1
+	for ancestor := node.parent; ancestor != nil; ancestor = ancestor.parent {
+		ancestor.count -= removed
+		ancestor.cost -= node.cost
+		if ancestor.index >= 0 {
+			heap.Fix(h, ancestor.index)
+		}
+	}
+	node.walk(func(n *coarseNode) {
+		if n.index >= 0 {
+			heap.Remove(h, n.index)
+		}
+	})
+	node.left, node.right = nil, nil
+	node.count, node.cost = 1, 0
+	return removed
+}
+
+func (h coarseHeap) Len() int { return len(h) }
+
+func (h coarseHeap) Less(i, j int) bool {
+	if h[i].cost != h[j].cost {
+		return h[i].cost < h[j].cost
+	}
+	return h[i].boundary.Less(h[j].boundary)
+}
+
+func (h coarseHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+func (h *coarseHeap) Push(x any) {
+	if node, ok := x.(*coarseNode); ok {
+		node.index = len(*h)
+		*h = append(*h, node)
+	}
+}
+
+func (h *coarseHeap) Pop() any {
+	old := *h
+	node := old[len(old)-1]
+	node.index = -1
+	*h = old[:len(old)-1]
+	return node
+}
+
+// prefixSize returns the (approximate) number of addresses in the prefix
+func prefixSize(prefix netip.Prefix) float64 {
+	return math.Ldexp(1, prefix.Addr().BitLen()-prefix.Bits())
+}
+
+// halfAddr returns the first address of the second half of the masked prefix
+func halfAddr(prefix netip.Prefix) netip.Addr {
+	addr := prefix.Addr().AsSlice()
+	addr[prefix.Bits()/8] |= 0x80 >> (prefix.Bits() % 8)
+	half, _ := netip.AddrFromSlice(addr)
+	return half
+}
+
+// AddressCount returns the number of IPv4 (if ipv4 is true) or IPv6 addresses in the non-overlapping prefixes
+func AddressCount(prefixes []netip.Prefix, ipv4 bool) *big.Int {
+	count := new(big.Int)
+	for _, prefix := range prefixes {
+		if prefix.Addr().Is4() == ipv4 {
+			count.Add(count, new(big.Int).Lsh(big.NewInt(1), uint(prefix.Addr().BitLen()-prefix.Bits())))
+		}
+	}
+	return count
+}
+
+// commonSupernet returns the longest prefix containing both prefixes of the same family
+func commonSupernet(a, b netip.Prefix) (netip.Prefix, bool) {
+	if a.Addr().BitLen() != b.Addr().BitLen() {
+		return netip.Prefix{}, false
+	}
+	for bits := min(a.Bits(), b.Bits()); bits >= 0; bits-- {
+		supernet := netip.PrefixFrom(a.Addr(), bits).Masked()
+		if supernet.Contains(b.Addr()) {
+			return supernet, true
+		}
+	}
+	return netip.Prefix{}, false
+}
+
+// overlapsRanges tells if the range overlaps with any of the merged and sorted ranges
+func overlapsRanges(r ipRange, ranges []ipRange) bool {
+	i := sort.Search(len(ranges), func(i int) bool {
+		return ranges[i].to.Compare(r.from) >= 0
+	})
+	return i < len(ranges) && ranges[i].from.Compare(r.to) <= 0
+}
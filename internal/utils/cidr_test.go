@@ -0,0 +1,153 @@
+package utils
+
+import (
+	"math/big"
+	"math/rand/v2"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func parsePrefixes(cidrs []string) []netip.Prefix {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		prefixes = append(prefixes, netip.MustParsePrefix(cidr))
+	}
+	return prefixes
+}
+
+func prefixStrings(prefixes []netip.Prefix) []string {
+	cidrs := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		cidrs = append(cidrs, prefix.String())
+	}
+	return cidrs
+}
+
+func TestSummarizeCIDRs(t *testing.T) {
+	tests := []struct {
+		name  string
+		cidrs []string
+		want  []string
+	}{
+		{name: "empty", cidrs: []string{}, want: []string{}},
+		{name: "contained", cidrs: []string{"10.1.1.1/32", "10.0.0.0/8", "10.2.0.0/16"}, want: []string{"10.0.0.0/8"}},
+		{name: "adjacent", cidrs: []string{"10.0.0.1/32", "10.0.0.0/32", "10.0.0.2/31"}, want: []string{"10.0.0.0/30"}},
+		{name: "adjacent unaligned", cidrs: []string{"10.0.0.1/32", "10.0.0.2/32"}, want: []string{"10.0.0.1/32", "10.0.0.2/32"}},
+		{name: "unaligned range", cidrs: []string{"10.0.0.1/32", "10.0.0.2/31", "10.0.0.4/30", "10.0.0.8/32"}, want: []string{"10.0.0.1/32", "10.0.0.2/31", "10.0.0.4/30", "10.0.0.8/32"}},
+		{name: "duplicates", cidrs: []string{"1.2.3.4/32", "1.2.3.4/32"}, want: []string{"1.2.3.4/32"}},
+		{name: "unmasked", cidrs: []string{"10.1.2.3/8"}, want: []string{"10.0.0.0/8"}},
+		{name: "everything", cidrs: []string{"0.0.0.0/1", "128.0.0.0/1", "255.255.255.255/32"}, want: []string{"0.0.0.0/0"}},
+		{name: "ipv6", cidrs: []string{"2001:db8::/128", "2001:db8::1/128", "fd00::/8", "fd12::1/128"}, want: []string{"2001:db8::/127", "fd00::/8"}},
+		{name: "families are not merged", cidrs: []string{"::/0", "10.0.0.0/8"}, want: []string{"10.0.0.0/8", "::/0"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := prefixStrings(SummarizeCIDRs(parsePrefixes(tt.cidrs)))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("SummarizeCIDRs(%#v) = %#v, want %#v", tt.cidrs, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubtractCIDRs(t *testing.T) {
+	tests := []struct {
+		name     string
+		cidrs    []string
+		excluded []string
+		want     []string
+	}{
+		{name: "no exclusions", cidrs: []string{"10.1.2.3/8"}, excluded: nil, want: []string{"10.1.2.3/8"}},
+		{name: "exact", cidrs: []string{"1.2.3.4/32", "5.6.7.8/32"}, excluded: []string{"1.2.3.4/32"}, want: []string{"5.6.7.8/32"}},
+		{name: "covered", cidrs: []string{"10.1.1.1/32"}, excluded: []string{"10.0.0.0/8"}, want: []string{}},
+		{name: "untouched", cidrs: []string{"10.1.2.3/8"}, excluded: []string{"192.168.0.0/16"}, want: []string{"10.1.2.3/8"}},
+		{name: "hole", cidrs: []string{"10.0.0.0/29"}, excluded: []string{"10.0.0.2/32"}, want: []string{"10.0.0.0/31", "10.0.0.3/32", "10.0.0.4/30"}},
+		{
+			name:     "range",
+			cidrs:    []string{"10.0.0.0/8"},
+			excluded: []string{"10.10.0.0/16"},
+			want: []string{
+				"10.0.0.0/13", "10.8.0.0/15", "10.11.0.0/16", "10.12.0.0/14",
+				"10.16.0.0/12", "10.32.0.0/11", "10.64.0.0/10", "10.128.0.0/9",
+			},
+		},
+		{name: "several holes", cidrs: []string{"10.0.0.0/28"}, excluded: []string{"10.0.0.0/30", "10.0.0.12/30", "10.0.0.6/31"}, want: []string{"10.0.0.4/31", "10.0.0.8/30"}},
+		{name: "edges", cidrs: []string{"0.0.0.0/0"}, excluded: []string{"0.0.0.0/1", "255.255.255.255/32"}, want: []string{"128.0.0.0/2", "192.0.0.0/3", "224.0.0.0/4", "240.0.0.0/5", "248.0.0.0/6", "252.0.0.0/7", "254.0.0.0/8", "255.0.0.0/9", "255.128.0.0/10", "255.192.0.0/11", "255.224.0.0/12", "255.240.0.0/13", "255.248.0.0/14", "255.252.0.0/15", "255.254.0.0/16", "255.255.0.0/17", "255.255.128.0/18", "255.255.192.0/19", "255.255.224.0/20", "255.255.240.0/21", "255.255.248.0/22", "255.255.252.0/23", "255.255.254.0/24", "255.255.255.0/25", "255.255.255.128/26", "255.255.255.192/27", "255.255.255.224/28", "255.255.255.240/29", "255.255.255.248/30", "255.255.255.252/31", "255.255.255.254/32"}},
+		{name: "ipv6", cidrs: []string{"fd00::/126", "10.0.0.0/30"}, excluded: []string{"fd00::1/128", "::/0"}, want: []string{"10.0.0.0/30"}},
+		{name: "ipv6 hole", cidrs: []string{"fd00::/126"}, excluded: []string{"fd00::1/128"}, want: []string{"fd00::/128", "fd00::2/127"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := prefixStrings(SubtractCIDRs(parsePrefixes(tt.cidrs), parsePrefixes(tt.excluded)))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("SubtractCIDRs(%#v, %#v) = %#v, want %#v", tt.cidrs, tt.excluded, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCoarsenCIDRs(t *testing.T) {
+	tests := []struct {
+		name     string
+		cidrs    []string
+		excluded []string
+		limit    int
+		minBits  int
+		want     []string
+		wantOK   bool
+	}{
+		{name: "fits", cidrs: []string{"10.0.0.1/32", "10.0.0.5/32"}, limit: 2, minBits: 16, want: []string{"10.0.0.1/32", "10.0.0.5/32"}, wantOK: true},
+		{name: "least extra space", cidrs: []string{"10.0.0.1/32", "10.0.0.2/32", "10.0.1.0/32"}, limit: 2, minBits: 16, want: []string{"10.0.0.0/30", "10.0.1.0/32"}, wantOK: true},
+		{name: "several rounds", cidrs: []string{"10.0.0.1/32", "10.0.0.2/32", "10.0.1.0/32", "10.0.1.1/32"}, limit: 1, minBits: 16, want: []string{"10.0.0.0/23"}, wantOK: true},
+		{name: "min prefix length", cidrs: []string{"10.0.0.1/32", "10.1.0.1/32"}, limit: 1, minBits: 16, want: []string{"10.0.0.1/32", "10.1.0.1/32"}, wantOK: false},
+		{
+			name:     "excluded ranges",
+			cidrs:    []string{"10.0.0.1/32", "10.0.0.2/32", "10.0.0.12/32", "10.0.0.14/32"},
+			excluded: []string{"10.0.0.0/32"},
+			limit:    3,
+			minBits:  16,
+			want:     []string{"10.0.0.1/32", "10.0.0.2/32", "10.0.0.12/30"},
+			wantOK:   true,
+		},
+		{name: "excluded ranges only", cidrs: []string{"10.0.0.1/32", "10.0.0.2/32"}, excluded: []string{"10.0.0.0/32"}, limit: 1, minBits: 16, want: []string{"10.0.0.1/32", "10.0.0.2/32"}, wantOK: false},
+		{name: "families", cidrs: []string{"10.0.0.1/32", "fd00::1/128", "fd00::2/128"}, limit: 2, minBits: 16, want: []string{"10.0.0.1/32", "fd00::/126"}, wantOK: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := CoarsenCIDRs(parsePrefixes(tt.cidrs), parsePrefixes(tt.excluded), tt.limit, tt.minBits, tt.minBits)
+			if ok != tt.wantOK || !reflect.DeepEqual(prefixStrings(got), tt.want) {
+				t.Fatalf("CoarsenCIDRs() = %#v, %v, want %#v, %v", prefixStrings(got), ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func BenchmarkCoarsenCIDRs(b *testing.B) {
+	// about the size of the AWS and Azure feeds together
+	r := rand.New(rand.NewPCG(1, 2))
+	prefixes := make([]netip.Prefix, 0, 20000)
+	for range 20000 {
+		addr := netip.AddrFrom4([4]byte{byte(1 + r.IntN(222)), byte(r.IntN(256)), byte(r.IntN(256)), 0})
+		prefixes = append(prefixes, netip.PrefixFrom(addr, 22+r.IntN(3)).Masked())
+	}
+
+	for b.Loop() {
+		if _, ok := CoarsenCIDRs(prefixes, nil, 500, 8, 32); !ok {
+			b.Fatalf("CoarsenCIDRs() didn't fit into the limit")
+		}
+	}
+}
+
+func TestAddressCount(t *testing.T) {
+	prefixes := parsePrefixes([]string{"10.0.0.0/30", "10.0.1.0/32", "::/1", "fd00::/127"})
+	if got := AddressCount(prefixes, true); got.String() != "5" {
+		t.Fatalf("AddressCount(ipv4) = %s, want 5", got)
+	}
+	if got, want := AddressCount(prefixes, false), new(big.Int).Add(new(big.Int).Lsh(big.NewInt(1), 127), big.NewInt(2)); got.Cmp(want) != 0 {
+		t.Fatalf("AddressCount(ipv6) = %s, want %s", got, want)
+	}
+}
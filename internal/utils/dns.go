@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"context"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDNSWorkers = 16
+	defaultDNSTimeout = 5 * time.Second
+)
+
+// HostResolver determines CIDRs of many hosts concurrently
+type HostResolver struct {
+	Resolver Resolver      // DNS resolver, the system one if nil
+	Workers  int           // max concurrent lookups, 16 by default
+	Timeout  time.Duration // per-lookup timeout, 5s by default
+	Cache    *DNSCache     // last known good CIDRs of hostnames, disabled if nil
+}
+
+// DetermineCIDRs determines CIDRs of the hosts (see DetermineCIDRs) using a bounded pool of workers.
+// Each host is resolved once, results are in the order of the hosts.
+// Lookups not finished before the ctx is done return no CIDRs
+func (r *HostResolver) DetermineCIDRs(ctx context.Context, hosts []string) [][]netip.Prefix {
+	results := make([][]netip.Prefix, len(hosts))
+	positions := map[string][]int{}
+	queue := []string{}
+	for i, host := range hosts {
+		if _, ok := positions[host]; !ok {
+			queue = append(queue, host)
+		}
+		positions[host] = append(positions[host], i)
+	}
+
+	workers := r.Workers
+	if workers <= 0 {
+		workers = defaultDNSWorkers
+	}
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for range min(workers, len(queue)) {
+		wg.Go(func() {
+			for host := range jobs {
+				cidrs := r.determine(ctx, host)
+				for _, i := range positions[host] {
+					results[i] = cidrs
+				}
+			}
+		})
+	}
+	for _, host := range queue {
+		jobs <- host
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// determine determines CIDRs of the host within the per-lookup timeout.
+// Resolved hostnames are cached, and the cached CIDRs are used if the lookup fails
+func (r *HostResolver) determine(ctx context.Context, host string) []netip.Prefix {
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = defaultDNSTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cidrs := DetermineCIDRs(ctx, r.Resolver, host)
+	if !isHostname(host) {
+		return cidrs
+	}
+	if len(cidrs) > 0 {
+		r.Cache.store(host, cidrs)
+		return cidrs
+	}
+	if cached := r.Cache.fallback(host); len(cached) > 0 {
+		return cached
+	}
+	return cidrs
+}
@@ -0,0 +1,123 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const defaultDNSCacheGrace = 24 * time.Hour
+
+// DNSCache keeps the last known good CIDRs of hostnames in the state file,
+// to fall back to them for a grace period when the lookups fail
+type DNSCache struct {
+	mu      sync.Mutex
+	path    string
+	grace   time.Duration
+	entries map[string]*dnsCacheEntry
+	now     func() time.Time
+}
+
+// dnsCacheEntry is the successful resolution of a hostname
+type dnsCacheEntry struct {
+	CIDRs      []netip.Prefix `json:"cidrs"`
+	ResolvedAt time.Time      `json:"resolved_at"`
+}
+
+// LoadDNSCache reads the state file, a missing or unreadable file results in the empty cache.
+// Resolved CIDRs may be used for the grace period since their resolution (24h by default)
+func LoadDNSCache(path string, grace time.Duration) *DNSCache {
+	if grace <= 0 {
+		grace = defaultDNSCacheGrace
+	}
+	cache := &DNSCache{path: path, grace: grace, entries: map[string]*dnsCacheEntry{}, now: time.Now}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			Log("ERROR: cannot read DNS state", path, ":", err)
+		}
+		return cache
+	}
+	if err := json.Unmarshal(contents, &cache.entries); err != nil {
+		Log("ERROR: cannot parse DNS state", path, ":", err)
+		cache.entries = map[string]*dnsCacheEntry{}
+	}
+	return cache
+}
+
+// Save writes the cache into the state file, dropping entries past their grace period
+func (c *DNSCache) Save() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	for host, entry := range c.entries {
+		if entry == nil || now.After(entry.ResolvedAt.Add(c.grace)) {
+			delete(c.entries, host)
+		}
+	}
+	contents, err := json.MarshalIndent(c.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o700); err != nil {
+		return err
+	}
+	return writeFileAtomic(c.path, contents)
+}
+
+// store records the successful resolution of the host
+func (c *DNSCache) store(host string, cidrs []netip.Prefix) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[host] = &dnsCacheEntry{CIDRs: cidrs, ResolvedAt: c.now()}
+}
+
+// fallback returns the cached CIDRs of the host that failed to resolve, if they are within the grace period
+func (c *DNSCache) fallback(host string) []netip.Prefix {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := c.entries[host]
+	if entry == nil {
+		return nil
+	}
+	if expiresAt := entry.ResolvedAt.Add(c.grace); c.now().After(expiresAt) {
+		Debug("cached CIDRs of", host, "expired at", expiresAt.Format(time.RFC3339))
+		return nil
+	}
+	Log("WARNING: cannot resolve", host, ", using cached CIDRs resolved at", entry.ResolvedAt.Format(time.RFC3339))
+	return entry.CIDRs
+}
+
+// isHostname tells if the host is a hostname or a typed entry (e.g. mx:example.com) to look up, not an IP address or CIDR
+func isHostname(host string) bool {
+	if _, _, ok := recordEntry(host); ok {
+		return true
+	}
+	if _, err := netip.ParsePrefix(host); err == nil {
+		return false
+	}
+	if _, err := netip.ParseAddr(host); err == nil {
+		return false
+	}
+	if _, ok, _ := parseRange(host); ok {
+		return false
+	}
+	return isDomain(host)
+}
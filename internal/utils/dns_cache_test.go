@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDNSCache_Fallback(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := LoadDNSCache(filepath.Join(t.TempDir(), "dns.json"), time.Hour)
+	cache.now = func() time.Time { return now }
+	cache.store("web.example.com", parsePrefixes([]string{"1.2.3.4/32"}))
+
+	tests := []struct {
+		name  string
+		after time.Duration
+		host  string
+		want  []string
+	}{
+		{name: "fresh", after: 30 * time.Second, host: "web.example.com", want: []string{"1.2.3.4/32"}},
+		{name: "older", after: 30 * time.Minute, host: "web.example.com", want: []string{"1.2.3.4/32"}},
+		{name: "expired", after: 2 * time.Hour, host: "web.example.com", want: []string{}},
+		{name: "unknown", host: "db.example.com", want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache.now = func() time.Time { return now.Add(tt.after) }
+			if got := prefixStrings(cache.fallback(tt.host)); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("fallback() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDNSCache_SaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "dns.json")
+	now := time.Now()
+	cache := LoadDNSCache(path, time.Hour)
+	cache.now = func() time.Time { return now.Add(-3 * time.Hour) }
+	cache.store("old.example.com", parsePrefixes([]string{"5.6.7.8/32"}))
+	cache.now = func() time.Time { return now }
+	cache.store("web.example.com", parsePrefixes([]string{"1.2.3.4/32", "fd00::1/128"}))
+	if err := cache.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	loaded := LoadDNSCache(path, time.Hour)
+	if _, ok := loaded.entries["old.example.com"]; ok {
+		t.Fatalf("LoadDNSCache() kept the entry past its grace period")
+	}
+	if got, want := prefixStrings(loaded.fallback("web.example.com")), []string{"1.2.3.4/32", "fd00::1/128"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("fallback() = %#v, want %#v", got, want)
+	}
+}
+
+func TestLoadDNSCache_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dns.json")
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	cache := LoadDNSCache(path, 0)
+	if len(cache.entries) != 0 || cache.grace != defaultDNSCacheGrace {
+		t.Fatalf("LoadDNSCache() = %#v, want empty cache with defaults", cache)
+	}
+}
+
+func TestDNSCache_Nil(t *testing.T) {
+	var cache *DNSCache
+	cache.store("web.example.com", parsePrefixes([]string{"1.2.3.4/32"}))
+	if got := cache.fallback("web.example.com"); got != nil {
+		t.Fatalf("fallback() = %#v, want nil", got)
+	}
+	if err := cache.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+}
+
+func TestHostResolver_DetermineCIDRs_Cache(t *testing.T) {
+	resolver := &fakeResolver{ips: map[string][]string{"web.example.com": {"1.2.3.4"}}}
+	cache := LoadDNSCache(filepath.Join(t.TempDir(), "dns.json"), 0)
+	hr := &HostResolver{Resolver: resolver, Cache: cache}
+
+	hr.DetermineCIDRs(context.Background(), []string{"web.example.com", "10.0.0.1"})
+	if _, ok := cache.entries["10.0.0.1"]; ok {
+		t.Fatalf("DetermineCIDRs() cached the IP address")
+	}
+
+	delete(resolver.ips, "web.example.com")
+	got := hr.DetermineCIDRs(context.Background(), []string{"web.example.com", "db.example.com"})
+	if want := []string{"1.2.3.4/32"}; !reflect.DeepEqual(prefixStrings(got[0]), want) {
+		t.Fatalf("DetermineCIDRs() = %#v, want cached %#v", prefixStrings(got[0]), want)
+	}
+	if len(got[1]) != 0 {
+		t.Fatalf("DetermineCIDRs() = %#v, want no CIDRs for the unknown host", prefixStrings(got[1]))
+	}
+}
@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHostResolver_DetermineCIDRs(t *testing.T) {
+	resolver := &HostResolver{Workers: 2}
+	hosts := []string{"10.0.0.2", "10.0.0.1/24", "10.0.0.2", "bad_host", "fd00::1"}
+	got := resolver.DetermineCIDRs(context.Background(), hosts)
+	want := [][]string{{"10.0.0.2/32"}, {"10.0.0.0/24"}, {"10.0.0.2/32"}, {}, {"fd00::1/128"}}
+	if len(got) != len(want) {
+		t.Fatalf("DetermineCIDRs() = %#v, want %#v", got, want)
+	}
+	for i := range got {
+		if !reflect.DeepEqual(prefixStrings(got[i]), want[i]) {
+			t.Fatalf("DetermineCIDRs()[%d] = %#v, want %#v", i, prefixStrings(got[i]), want[i])
+		}
+	}
+}
+
+func TestHostResolver_DetermineCIDRs_Deadline(t *testing.T) {
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	resolver := &HostResolver{Timeout: time.Second}
+	got := resolver.DetermineCIDRs(ctx, []string{"example.com", "1.2.3.4"})
+	if len(got[0]) != 0 {
+		t.Fatalf("DetermineCIDRs() = %#v, want no CIDRs after the deadline", got[0])
+	}
+	if want := []string{"1.2.3.4/32"}; !reflect.DeepEqual(prefixStrings(got[1]), want) {
+		t.Fatalf("DetermineCIDRs() = %#v, want %#v", prefixStrings(got[1]), want)
+	}
+}
+
+func TestHostResolver_DetermineCIDRs_Empty(t *testing.T) {
+	if got := (&HostResolver{}).DetermineCIDRs(context.Background(), nil); len(got) != 0 {
+		t.Fatalf("DetermineCIDRs() = %#v, want empty", got)
+	}
+}
@@ -0,0 +1,217 @@
+package utils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	defaultFetchTimeout = 30 * time.Second
+	defaultFetchGrace   = 24 * time.Hour
+)
+
+// errUnavailable marks the download errors the cached copy is used for: network errors and 5xx responses
+var errUnavailable = errors.New("server is unavailable")
+
+// Fetcher downloads http(s) URLs using conditional requests (ETag and If-Modified-Since),
+// keeping the downloaded copies in the cache dir to use them when the server is unavailable
+type Fetcher struct {
+	Timeout    time.Duration     // request timeout, 30s by default
+	CacheDir   string            // dir to keep the downloaded copies in, disabled if empty
+	Grace      time.Duration     // how long the cached copy may be used when the server is unavailable, 24h by default
+	TokenFiles map[string]string // URL host -> file with the bearer token to send to it
+}
+
+// fetchMeta is the cached copy metadata
+type fetchMeta struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	FetchedAt    time.Time `json:"fetched_at"` // when the copy was downloaded or confirmed to be not modified
+}
+
+// IsURL tells if the source is an http(s) URL rather than a local path
+func IsURL(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+// Fetch returns the contents of the URL. If the server responds with 304 Not Modified, the cached copy is returned.
+// If the server is unreachable or responds with 5xx, the cached copy is used with a warning, unless it is older than the grace period.
+// Other responses (e.g. 401, 403, 404) are errors, so a revoked token or a removed document doesn't keep the stale copy in use
+func (f *Fetcher) Fetch(rawURL string) ([]byte, error) {
+	cached, meta := f.cached(rawURL)
+	body, newMeta, err := f.download(rawURL, meta)
+	if err != nil {
+		if cached == nil || !errors.Is(err, errUnavailable) {
+			return nil, err
+		}
+		grace := f.Grace
+		if grace <= 0 {
+			grace = defaultFetchGrace
+		}
+		if time.Since(meta.FetchedAt) > grace {
+			return nil, fmt.Errorf("%w, the cached copy fetched at %s is too old", err, meta.FetchedAt.Format(time.RFC3339))
+		}
+		Log("WARNING: cannot fetch", rawURL, ":", err, ", using the copy fetched at", meta.FetchedAt.Format(time.RFC3339))
+		return cached, nil
+	}
+	if newMeta == nil {
+		Debug(rawURL, "is not modified since", meta.FetchedAt.Format(time.RFC3339))
+		meta.FetchedAt = time.Now()
+		if err := f.storeMeta(rawURL, meta); err != nil {
+			Log("ERROR: cannot cache", rawURL, ":", err)
+		}
+		return cached, nil
+	}
+	if err := f.store(rawURL, body, newMeta); err != nil {
+		Log("ERROR: cannot cache", rawURL, ":", err)
+	}
+	return body, nil
+}
+
+// download requests the URL, conditionally if there is the cached copy. The nil meta means the copy is not modified
+func (f *Fetcher) download(rawURL string, cached *fetchMeta) ([]byte, *fetchMeta, error) {
+	timeout := f.Timeout
+	if timeout <= 0 {
+		timeout = defaultFetchTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := f.request(ctx, rawURL, cached)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", errUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %w", errUnavailable, err)
+		}
+		meta := &fetchMeta{URL: rawURL, ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified"), FetchedAt: time.Now()}
+		return body, meta, nil
+	case http.StatusNotModified:
+		if cached == nil {
+			return nil, nil, errors.New("server responded 304 Not Modified without a cached copy")
+		}
+		return nil, nil, nil
+	default:
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return nil, nil, fmt.Errorf("%w: HTTP status %s", errUnavailable, resp.Status)
+		}
+		return nil, nil, fmt.Errorf("unexpected HTTP status %s", resp.Status)
+	}
+}
+
+// request builds the GET request with the conditional headers of the cached copy and the bearer token of the URL host
+func (f *Fetcher) request(ctx context.Context, rawURL string, cached *fetchMeta) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil && cached.ETag != "" {
+		req.Header.Set("If-None-Match", cached.ETag)
+	}
+	if cached != nil && cached.LastModified != "" {
+		req.Header.Set("If-Modified-Since", cached.LastModified)
+	}
+
+	tokenFile := f.TokenFiles[req.URL.Host]
+	if tokenFile == "" {
+		tokenFile = f.TokenFiles[req.URL.Hostname()]
+	}
+	if tokenFile == "" {
+		return req, nil
+	}
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read token file: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	return req, nil
+}
+
+// cached returns the cached copy of the URL and its metadata, or nils if there is none
+func (f *Fetcher) cached(rawURL string) ([]byte, *fetchMeta) {
+	if f.CacheDir == "" {
+		return nil, nil
+	}
+	base := f.cachePath(rawURL)
+	metaJSON, err := os.ReadFile(base + ".json")
+	if err != nil {
+		return nil, nil
+	}
+	meta := &fetchMeta{}
+	if err := json.Unmarshal(metaJSON, meta); err != nil || meta.URL != rawURL {
+		return nil, nil
+	}
+	body, err := os.ReadFile(base)
+	if err != nil {
+		return nil, nil
+	}
+	return body, meta
+}
+
+// store writes the downloaded copy and its metadata into the cache dir
+func (f *Fetcher) store(rawURL string, body []byte, meta *fetchMeta) error {
+	if f.CacheDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(f.CacheDir, 0o700); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(f.cachePath(rawURL), body); err != nil {
+		return err
+	}
+	return f.storeMeta(rawURL, meta)
+}
+
+// storeMeta writes the metadata of the cached copy
+func (f *Fetcher) storeMeta(rawURL string, meta *fetchMeta) error {
+	metaJSON, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(f.cachePath(rawURL)+".json", metaJSON)
+}
+
+// cachePath returns the path of the cached copy, named by the URL hash
+func (f *Fetcher) cachePath(rawURL string) string {
+	hash := sha256.Sum256([]byte(rawURL))
+	return filepath.Join(f.CacheDir, hex.EncodeToString(hash[:]))
+}
+
+// URLExt returns the extension of the URL path, e.g. ".yml" for https://example.com/hosts.yml?ref=main
+func URLExt(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return filepath.Ext(u.Path)
+}
+
+// writeFileAtomic writes the temporary file first, so an interrupted run doesn't leave a truncated file
+func writeFileAtomic(path string, contents []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, contents, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
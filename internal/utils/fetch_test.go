@@ -0,0 +1,153 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFetcher_Fetch(t *testing.T) {
+	var requests, conditional int
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		auth = r.Header.Get("Authorization")
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			conditional++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("10.0.0.0/8\n")) //nolint:errcheck // test server
+	}))
+	defer server.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("secret\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	fetcher := &Fetcher{CacheDir: filepath.Join(t.TempDir(), "cache"), TokenFiles: map[string]string{server.Listener.Addr().String(): tokenFile}}
+	rawURL := server.URL + "/list.txt"
+
+	for i := range 2 {
+		got, err := fetcher.Fetch(rawURL)
+		if err != nil {
+			t.Fatalf("Fetch() #%d error = %v", i, err)
+		}
+		if string(got) != "10.0.0.0/8\n" {
+			t.Fatalf("Fetch() #%d = %q", i, got)
+		}
+	}
+	if requests != 2 || conditional != 1 {
+		t.Fatalf("Fetch() made %d requests (%d conditional), want 2 (1 conditional)", requests, conditional)
+	}
+	if auth != "Bearer secret" {
+		t.Fatalf("Fetch() Authorization = %q, want the token from the file", auth)
+	}
+
+	// the cached copy is used when the server is unreachable
+	server.Close()
+	got, err := fetcher.Fetch(rawURL)
+	if err != nil || string(got) != "10.0.0.0/8\n" {
+		t.Fatalf("Fetch() = %q, %v, want the cached copy", got, err)
+	}
+	if _, err := (&Fetcher{}).Fetch(rawURL); err == nil {
+		t.Fatalf("Fetch() expected error without the cached copy")
+	}
+}
+
+func TestFetcher_Fetch_Errors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/not-modified" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		http.Error(w, "oops", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	fetcher := &Fetcher{CacheDir: t.TempDir()}
+	for _, path := range []string{"/error", "/not-modified"} {
+		if _, err := fetcher.Fetch(server.URL + path); err == nil {
+			t.Fatalf("Fetch(%s) expected error", path)
+		}
+	}
+	fetcher.TokenFiles = map[string]string{"127.0.0.1": filepath.Join(t.TempDir(), "missing")}
+	if _, err := fetcher.Fetch(server.URL + "/error"); err == nil {
+		t.Fatalf("Fetch() expected error for missing token file")
+	}
+}
+
+func TestFetcher_Fetch_Fallback(t *testing.T) {
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status != http.StatusOK {
+			http.Error(w, "oops", status)
+			return
+		}
+		w.Write([]byte("10.0.0.0/8\n")) //nolint:errcheck // test server
+	}))
+	defer server.Close()
+
+	fetcher := &Fetcher{CacheDir: t.TempDir()}
+	rawURL := server.URL + "/list.txt"
+	if _, err := fetcher.Fetch(rawURL); err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+
+	// the cached copy is used for 5xx responses only
+	for _, tt := range []struct {
+		status  int
+		wantErr bool
+	}{
+		{status: http.StatusServiceUnavailable},
+		{status: http.StatusUnauthorized, wantErr: true},
+		{status: http.StatusForbidden, wantErr: true},
+		{status: http.StatusNotFound, wantErr: true},
+	} {
+		status = tt.status
+		got, err := fetcher.Fetch(rawURL)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("Fetch() with HTTP %d error = %v, wantErr %v", tt.status, err, tt.wantErr)
+		}
+		if !tt.wantErr && string(got) != "10.0.0.0/8\n" {
+			t.Fatalf("Fetch() with HTTP %d = %q, want the cached copy", tt.status, got)
+		}
+	}
+
+	// the cached copy older than the grace period is not used
+	status = http.StatusBadGateway
+	fetcher.Grace = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	if _, err := fetcher.Fetch(rawURL); err == nil {
+		t.Fatalf("Fetch() expected error for the cached copy older than the grace period")
+	}
+}
+
+func TestIsURL(t *testing.T) {
+	for source, want := range map[string]bool{
+		"https://example.com/hosts": true,
+		"http://example.com/hosts":  true,
+		"/etc/ansible/hosts":        false,
+		"ftp://example.com/hosts":   false,
+	} {
+		if got := IsURL(source); got != want {
+			t.Fatalf("IsURL(%q) = %v, want %v", source, got, want)
+		}
+	}
+}
+
+func TestURLExt(t *testing.T) {
+	for rawURL, want := range map[string]string{
+		"https://example.com/inventory/hosts.yml?ref=main": ".yml",
+		"https://example.com/hosts":                        "",
+		"https://example.com/hosts.ini#prod":               ".ini",
+	} {
+		if got := URLExt(rawURL); got != want {
+			t.Fatalf("URLExt(%q) = %q, want %q", rawURL, got, want)
+		}
+	}
+}
@@ -1,10 +1,12 @@
 package utils
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"net"
+	"net/netip"
 	"regexp"
-	"sort"
+	"slices"
 	"strings"
 )
 
@@ -12,43 +14,104 @@ var domainRegex = regexp.MustCompile(`^(?:[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-
 
 // DetermineCIDRs takes a host (CIDR or IPv4/IPv6 address or hostname) and determines the network CIDRs for it.
 // For IP addresses, a /32 or /128 CIDR is returned depending on the address type (IPv4 or IPv6, respectively).
+// For IP ranges (192.0.2.10-192.0.2.57), netmasks (192.0.2.0/255.255.255.0), and IPv4 wildcards (192.0.2.*),
+// the minimal list of CIDRs covering the range is returned.
 // For hostnames, a combination of multiple IPv4 and IPv6 CIDRs may be returned, depending on A/AAAA DNS records.
-func DetermineCIDRs(host string) []string {
-	// if CIDR, return as is
-	if _, _, err := net.ParseCIDR(host); err == nil {
-		return []string{host}
+// CIDRs with host bits set are normalized to their network address, with a warning.
+// Typed entries resolve the targets of DNS records: srv:_service._proto.example.com (SRV targets), mx:example.com (mail exchangers),
+// and spf:example.com (ip4, ip6, a, mx, and include mechanisms of the SPF record).
+// DNS lookups are done with the resolver (the system one if nil) and limited by the ctx, timed out lookups are logged
+func DetermineCIDRs(ctx context.Context, resolver Resolver, host string) []netip.Prefix {
+	// if CIDR, return it normalized
+	if prefix, err := netip.ParsePrefix(host); err == nil {
+		return []netip.Prefix{CanonicalPrefix(prefix, host)}
 	}
 	// if IP, return CIDR
-	if ip := net.ParseIP(host); ip != nil {
-		return []string{ipToCIDR(ip)}
+	if ip, err := netip.ParseAddr(host); err == nil && ip.Zone() == "" {
+		return []netip.Prefix{addrToPrefix(ip)}
+	}
+	// if IP range, netmask, or wildcard, return the CIDRs covering it
+	if cidrs, ok, err := parseRange(host); ok {
+		if err != nil {
+			Log("WARNING: invalid IP range", host, ":", err)
+			return []netip.Prefix{}
+		}
+		return cidrs
+	}
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	if record, domain, ok := recordEntry(host); ok {
+		return recordCIDRs(ctx, resolver, record, domain)
 	}
 	if !isDomain(host) {
-		return []string{}
+		return []netip.Prefix{}
 	}
 
 	// if domain with A or AAAA records, return CIDR
-	if ips, err := net.LookupIP(host); err == nil && len(ips) > 0 {
-		result := make([]string, 0, len(ips))
+	ips, err := resolver.LookupNetIP(ctx, "ip", host)
+	if err == nil && len(ips) > 0 {
+		result := make([]netip.Prefix, 0, len(ips))
 		for _, ip := range ips {
-			result = append(result, ipToCIDR(ip))
+			result = append(result, addrToPrefix(ip))
 		}
 		return result
 	}
+	if isTimeout(ctx, err) {
+		Log("WARNING: DNS lookup of", host, "timed out")
+		return []netip.Prefix{}
+	}
 
 	// if domain with CNAME record, run again
-	if cname, err := net.LookupCNAME(host); err == nil && cname != "" {
-		return DetermineCIDRs(cname)
+	if cname, err := resolver.LookupCNAME(ctx, host); err == nil {
+		if cname = strings.TrimSuffix(cname, "."); cname != "" && cname != host {
+			return DetermineCIDRs(ctx, resolver, cname)
+		}
+	}
+
+	return []netip.Prefix{}
+}
+
+// isTimeout tells if the lookup failed due to a timeout or the ctx deadline
+func isTimeout(ctx context.Context, err error) bool {
+	if err == nil {
+		return false
 	}
+	var dnsErr *net.DNSError
+	return errors.Is(ctx.Err(), context.DeadlineExceeded) || (errors.As(err, &dnsErr) && dnsErr.IsTimeout)
+}
+
+// CanonicalPrefix returns the prefix normalized to its network address,
+// and logs a warning with the dropped host bits if they were set. The source is used in the warning only
+func CanonicalPrefix(prefix netip.Prefix, source string) netip.Prefix {
+	masked := prefix.Masked()
+	if masked != prefix {
+		Log("WARNING:", source, "has host bits", hostBits(prefix), "set, using", masked)
+	}
+	return masked
+}
 
-	return []string{}
+// SortPrefixes sorts prefixes: IPv4 first, then by address, then broader prefixes first
+func SortPrefixes(prefixes []netip.Prefix) {
+	slices.SortFunc(prefixes, comparePrefixes)
 }
 
-func SortIPs(ips []string) {
-	sort.Slice(ips, func(i, j int) bool {
-		ipI := strings.Split(ips[i], "/")[0]
-		ipJ := strings.Split(ips[j], "/")[0]
-		return bytes.Compare(net.ParseIP(ipI), net.ParseIP(ipJ)) < 0
-	})
+func comparePrefixes(a, b netip.Prefix) int {
+	if c := a.Addr().Compare(b.Addr()); c != 0 {
+		return c
+	}
+	return a.Bits() - b.Bits()
+}
+
+// hostBits returns the address with the network bits cleared
+func hostBits(prefix netip.Prefix) netip.Addr {
+	addr := prefix.Addr().AsSlice()
+	network := prefix.Masked().Addr().AsSlice()
+	for i := range addr {
+		addr[i] ^= network[i]
+	}
+	bits, _ := netip.AddrFromSlice(addr)
+	return bits
 }
 
 func isDomain(host string) bool {
@@ -58,9 +121,8 @@ func isDomain(host string) bool {
 	return domainRegex.MatchString(host)
 }
 
-func ipToCIDR(ip net.IP) string {
-	if ip.To4() != nil {
-		return ip.String() + "/32"
-	}
-	return ip.String() + "/128"
+// addrToPrefix returns the single address prefix (/32 for IPv4, /128 for IPv6), IPv4-mapped IPv6 addresses are unmapped
+func addrToPrefix(ip netip.Addr) netip.Prefix {
+	ip = ip.Unmap()
+	return netip.PrefixFrom(ip, ip.BitLen())
 }
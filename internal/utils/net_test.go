@@ -1,6 +1,10 @@
 package utils
 
 import (
+	"bytes"
+	"context"
+	"log"
+	"net/netip"
 	"reflect"
 	"testing"
 )
@@ -14,12 +18,19 @@ func TestDetermineCIDRs_NoDNS(t *testing.T) {
 		{name: "ipv4", host: "1.2.3.4", want: []string{"1.2.3.4/32"}},
 		{name: "ipv6", host: "2001:db8::1", want: []string{"2001:db8::1/128"}},
 		{name: "cidr", host: "10.0.0.0/8", want: []string{"10.0.0.0/8"}},
+		{name: "cidr with host bits", host: "10.0.0.5/8", want: []string{"10.0.0.0/8"}},
+		{name: "ipv6 cidr with host bits", host: "fd00::1/64", want: []string{"fd00::/64"}},
+		{name: "ipv4-mapped ipv6", host: "::ffff:1.2.3.4", want: []string{"1.2.3.4/32"}},
+		{name: "range", host: "10.0.0.0-10.0.1.255", want: []string{"10.0.0.0/23"}},
+		{name: "netmask", host: "10.0.0.0/255.255.0.0", want: []string{"10.0.0.0/16"}},
+		{name: "wildcard", host: "10.1.*.*", want: []string{"10.1.0.0/16"}},
+		{name: "invalid range", host: "10.0.0.9-10.0.0.1", want: []string{}},
 		{name: "invalid", host: "not_a_host", want: []string{}},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := DetermineCIDRs(tt.host)
+			got := prefixStrings(DetermineCIDRs(context.Background(), nil, tt.host))
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Fatalf("DetermineCIDRs(%q) = %#v, want %#v", tt.host, got, tt.want)
 			}
@@ -27,22 +38,42 @@ func TestDetermineCIDRs_NoDNS(t *testing.T) {
 	}
 }
 
-func TestSortIPs(t *testing.T) {
-	ips := []string{
+func TestSortPrefixes(t *testing.T) {
+	prefixes := parsePrefixes([]string{
 		"2001:db8::2/128",
 		"10.0.0.2/32",
+		"10.0.0.0/8",
 		"10.0.0.1/32",
+		"::/0",
 		"2001:db8::1/128",
-	}
-	SortIPs(ips)
+	})
+	SortPrefixes(prefixes)
 	want := []string{
+		"10.0.0.0/8",
 		"10.0.0.1/32",
 		"10.0.0.2/32",
+		"::/0",
 		"2001:db8::1/128",
 		"2001:db8::2/128",
 	}
-	if !reflect.DeepEqual(ips, want) {
-		t.Fatalf("SortIPs() = %#v, want %#v", ips, want)
+	if got := prefixStrings(prefixes); !reflect.DeepEqual(got, want) {
+		t.Fatalf("SortPrefixes() = %#v, want %#v", got, want)
+	}
+}
+
+func TestCanonicalPrefix(t *testing.T) {
+	var buf bytes.Buffer
+	SetLogger(log.New(&buf, "", 0))
+	defer SetLogger(nil)
+
+	if got := CanonicalPrefix(netip.MustParsePrefix("10.0.0.0/8"), "test"); got.String() != "10.0.0.0/8" || buf.Len() != 0 {
+		t.Fatalf("CanonicalPrefix() = %s, log %q", got, buf.String())
+	}
+	if got := CanonicalPrefix(netip.MustParsePrefix("10.1.0.5/8"), "test"); got.String() != "10.0.0.0/8" {
+		t.Fatalf("CanonicalPrefix() = %s, want 10.0.0.0/8", got)
+	}
+	if want := "WARNING: test has host bits 0.1.0.5 set, using 10.0.0.0/8\n"; buf.String() != want {
+		t.Fatalf("CanonicalPrefix() log = %q, want %q", buf.String(), want)
 	}
 }
 
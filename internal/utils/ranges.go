@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// parseRange parses the IP range notations: dash ranges (192.0.2.10-192.0.2.57, fd00::1-fd00::ff),
+// netmasks (192.0.2.0/255.255.255.0), and IPv4 wildcards (192.0.2.*), returning the minimal list of CIDRs covering the range.
+// The ok is false if the entry is not a range, and the error is returned for malformed ranges
+func parseRange(entry string) (cidrs []netip.Prefix, ok bool, err error) {
+	if from, to, found := strings.Cut(entry, "-"); found {
+		return parseDashRange(strings.TrimSpace(from), strings.TrimSpace(to))
+	}
+	if addr, mask, found := strings.Cut(entry, "/"); found && strings.Contains(mask, ".") {
+		return parseNetmask(addr, mask)
+	}
+	if strings.Contains(entry, "*") {
+		return parseWildcard(entry)
+	}
+	return nil, false, nil
+}
+
+// parseDashRange converts the first-last addresses range to CIDRs
+func parseDashRange(from, to string) ([]netip.Prefix, bool, error) {
+	first, errFirst := netip.ParseAddr(from)
+	last, errLast := netip.ParseAddr(to)
+	if errFirst != nil || errLast != nil || first.Zone() != "" || last.Zone() != "" { // not a range, e.g. a hostname with a dash
+		return nil, false, nil
+	}
+	first, last = first.Unmap(), last.Unmap()
+	if first.Is4() != last.Is4() {
+		return nil, true, errors.New("range mixes IPv4 and IPv6 addresses")
+	}
+	if first.Compare(last) > 0 {
+		return nil, true, fmt.Errorf("range starts after its end %s", last)
+	}
+	return rangePrefixes(ipRange{from: first, to: last}), true, nil
+}
+
+// parseNetmask converts the IPv4 address with the dotted netmask to CIDR
+func parseNetmask(address, netmask string) ([]netip.Prefix, bool, error) {
+	addr, errAddr := netip.ParseAddr(address)
+	if errAddr != nil {
+		return nil, false, nil
+	}
+	mask, err := netip.ParseAddr(netmask)
+	if err != nil || !mask.Is4() || !addr.Unmap().Is4() {
+		return nil, true, fmt.Errorf("invalid IPv4 netmask %s", netmask)
+	}
+	maskBits := mask.As4()
+	value := uint32(maskBits[0])<<24 | uint32(maskBits[1])<<16 | uint32(maskBits[2])<<8 | uint32(maskBits[3])
+	ones := bits.LeadingZeros32(^value)
+	if value<<ones != 0 {
+		return nil, true, fmt.Errorf("netmask %s is not contiguous", netmask)
+	}
+	prefix := netip.PrefixFrom(addr.Unmap(), ones)
+	return []netip.Prefix{CanonicalPrefix(prefix, address+"/"+netmask)}, true, nil
+}
+
+// parseWildcard converts the IPv4 address with trailing wildcard octets (e.g. 10.0.*.*) to CIDR
+func parseWildcard(entry string) ([]netip.Prefix, bool, error) {
+	octets := strings.Split(entry, ".")
+	if len(octets) != net.IPv4len {
+		return nil, false, nil
+	}
+	ones := -1
+	for i, octet := range octets {
+		switch {
+		case octet == "*":
+			if ones < 0 {
+				ones = i * 8
+			}
+			octets[i] = "0"
+		case ones >= 0:
+			return nil, true, errors.New("only the trailing octets may be wildcards")
+		}
+	}
+	addr, errAddr := netip.ParseAddr(strings.Join(octets, "."))
+	if errAddr != nil || ones < 0 { // not a wildcard IPv4 address
+		return nil, false, nil
+	}
+	return []netip.Prefix{netip.PrefixFrom(addr, ones)}, true, nil
+}
@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		entry   string
+		want    []string
+		wantOK  bool
+		wantErr bool
+	}{
+		{entry: "192.0.2.10-192.0.2.57", want: []string{"192.0.2.10/31", "192.0.2.12/30", "192.0.2.16/28", "192.0.2.32/28", "192.0.2.48/29", "192.0.2.56/31"}, wantOK: true},
+		{entry: "10.0.0.0 - 10.0.255.255", want: []string{"10.0.0.0/16"}, wantOK: true},
+		{entry: "10.0.0.1-10.0.0.1", want: []string{"10.0.0.1/32"}, wantOK: true},
+		{entry: "fd00::-fd00::ff", want: []string{"fd00::/120"}, wantOK: true},
+		{entry: "::ffff:10.0.0.0-10.0.0.3", want: []string{"10.0.0.0/30"}, wantOK: true},
+		{entry: "10.0.0.9-10.0.0.1", wantOK: true, wantErr: true},
+		{entry: "10.0.0.1-fd00::1", wantOK: true, wantErr: true},
+		{entry: "192.0.2.0/255.255.255.0", want: []string{"192.0.2.0/24"}, wantOK: true},
+		{entry: "192.0.2.5/255.255.255.252", want: []string{"192.0.2.4/30"}, wantOK: true},
+		{entry: "192.0.2.0/255.0.255.0", wantOK: true, wantErr: true},
+		{entry: "fd00::/255.255.0.0", wantOK: true, wantErr: true},
+		{entry: "10.0.*.*", want: []string{"10.0.0.0/16"}, wantOK: true},
+		{entry: "192.0.2.*", want: []string{"192.0.2.0/24"}, wantOK: true},
+		{entry: "*.*.*.*", want: []string{"0.0.0.0/0"}, wantOK: true},
+		{entry: "10.*.0.1", wantOK: true, wantErr: true},
+		{entry: "web-1.example.com"},
+		{entry: "*.example.com"},
+		{entry: "10.0.0.0/8"},
+		{entry: "example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.entry, func(t *testing.T) {
+			got, ok, err := parseRange(tt.entry)
+			if ok != tt.wantOK || (err != nil) != tt.wantErr {
+				t.Fatalf("parseRange() ok = %v, error = %v, want ok %v, wantErr %v", ok, err, tt.wantOK, tt.wantErr)
+			}
+			if tt.want != nil && !reflect.DeepEqual(prefixStrings(got), tt.want) {
+				t.Fatalf("parseRange() = %#v, want %#v", prefixStrings(got), tt.want)
+			}
+		})
+	}
+}
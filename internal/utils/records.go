@@ -0,0 +1,231 @@
+package utils
+
+import (
+	"context"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	RecordSRV = "srv" // srv:_service._proto.example.com, targets of the SRV records
+	RecordMX  = "mx"  // mx:example.com, mail exchangers of the domain
+	RecordSPF = "spf" // spf:example.com, senders permitted by the SPF record of the domain
+
+	// maxSPFLookups is the limit of DNS lookups while evaluating an SPF record, the same as RFC 7208 sets
+	maxSPFLookups = 10
+)
+
+// spfWalker collects the CIDRs permitted by SPF records, following include: and redirect= with loop and lookup limits
+type spfWalker struct {
+	resolver Resolver
+	lookups  int
+	seen     map[string]bool
+}
+
+// recordEntry splits the typed entry (e.g. srv:_matrix._tcp.example.com) into the record type and the domain
+func recordEntry(host string) (record, domain string, ok bool) {
+	record, domain, ok = strings.Cut(host, ":")
+	if !ok {
+		return "", "", false
+	}
+	record = strings.ToLower(record)
+	if record != RecordSRV && record != RecordMX && record != RecordSPF {
+		return "", "", false
+	}
+	domain = strings.TrimSuffix(domain, ".")
+	// underscores are allowed in the service labels of SRV names and SPF subdomains (e.g. _spf.example.com)
+	if !isDomain(strings.ReplaceAll(domain, "_", "x")) {
+		return "", "", false
+	}
+	return record, domain, true
+}
+
+// recordCIDRs resolves the targets of the typed entry to CIDRs
+func recordCIDRs(ctx context.Context, resolver Resolver, record, domain string) []netip.Prefix {
+	var cidrs []netip.Prefix
+	switch record {
+	case RecordSRV:
+		cidrs = srvCIDRs(ctx, resolver, domain)
+	case RecordMX:
+		cidrs = mxCIDRs(ctx, resolver, domain)
+	case RecordSPF:
+		walker := &spfWalker{resolver: resolver, seen: map[string]bool{}}
+		cidrs = walker.walk(ctx, domain)
+	}
+	SortPrefixes(cidrs)
+	return slices.Compact(cidrs)
+}
+
+// srvCIDRs resolves the targets of the SRV records
+func srvCIDRs(ctx context.Context, resolver Resolver, name string) []netip.Prefix {
+	_, records, err := resolver.LookupSRV(ctx, "", "", name)
+	if err != nil {
+		Debug("SRV lookup of", name, "failed:", err)
+		return []netip.Prefix{}
+	}
+	targets := make([]string, 0, len(records))
+	for _, record := range records {
+		targets = append(targets, record.Target)
+	}
+	return targetCIDRs(ctx, resolver, targets)
+}
+
+// mxCIDRs resolves the mail exchangers of the domain
+func mxCIDRs(ctx context.Context, resolver Resolver, domain string) []netip.Prefix {
+	records, err := resolver.LookupMX(ctx, domain)
+	if err != nil {
+		Debug("MX lookup of", domain, "failed:", err)
+		return []netip.Prefix{}
+	}
+	targets := make([]string, 0, len(records))
+	for _, record := range records {
+		targets = append(targets, record.Host)
+	}
+	return targetCIDRs(ctx, resolver, targets)
+}
+
+// targetCIDRs resolves the target hostnames of SRV or MX records, "." means no service
+func targetCIDRs(ctx context.Context, resolver Resolver, targets []string) []netip.Prefix {
+	cidrs := []netip.Prefix{}
+	for _, target := range targets {
+		if target = strings.TrimSuffix(target, "."); target != "" {
+			cidrs = append(cidrs, DetermineCIDRs(ctx, resolver, target)...)
+		}
+	}
+	return cidrs
+}
+
+// walk returns the CIDRs permitted by the SPF record of the domain
+func (w *spfWalker) walk(ctx context.Context, domain string) []netip.Prefix {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if strings.Contains(domain, "%") {
+		Debug("SPF domain", domain, "has macros, skipping")
+		return nil
+	}
+	if w.seen[domain] {
+		Log("WARNING: SPF record of", domain, "includes itself, skipping")
+		return nil
+	}
+	w.seen[domain] = true
+
+	cidrs := []netip.Prefix{}
+	var redirect string
+	var all bool
+	for _, term := range strings.Fields(w.record(ctx, domain))[1:] {
+		if target, ok := strings.CutPrefix(strings.ToLower(term), "redirect="); ok {
+			redirect = target
+			continue
+		}
+		all = all || spfMechanism(term) == "all"
+		cidrs = append(cidrs, w.term(ctx, domain, term)...)
+	}
+	// redirect is used only if there is no "all" mechanism, the same as SPF evaluation does
+	if redirect != "" && !all && w.lookup(domain) {
+		cidrs = append(cidrs, w.walk(ctx, redirect)...)
+	}
+	return cidrs
+}
+
+// record returns the "v=spf1" TXT record of the domain, or just "v=spf1" if there is none
+func (w *spfWalker) record(ctx context.Context, domain string) string {
+	txts, err := w.resolver.LookupTXT(ctx, domain)
+	if err != nil {
+		Debug("TXT lookup of", domain, "failed:", err)
+	}
+	for _, txt := range txts {
+		if fields := strings.Fields(txt); len(fields) > 0 && strings.EqualFold(fields[0], "v=spf1") {
+			return txt
+		}
+	}
+	Log("WARNING: domain", domain, "has no SPF record")
+	return "v=spf1"
+}
+
+// term returns the CIDRs of the SPF mechanism. Only pass (+) ip4, ip6, a, mx, and include mechanisms are used
+func (w *spfWalker) term(ctx context.Context, domain, term string) []netip.Prefix {
+	if strings.ContainsAny(term[:1], "-~?") {
+		return nil
+	}
+	term = strings.TrimPrefix(term, "+")
+	_, value, _ := strings.Cut(term, ":")
+	switch mechanism := spfMechanism(term); mechanism {
+	case "ip4", "ip6":
+		return spfIPCIDRs(domain, value)
+	case "include":
+		if w.lookup(domain) {
+			return w.walk(ctx, value)
+		}
+	case "a", RecordMX:
+		return w.hostCIDRs(ctx, domain, term)
+	default:
+		Debug("SPF record of", domain, "has unsupported mechanism", mechanism, ", skipping")
+	}
+	return nil
+}
+
+// hostCIDRs returns the CIDRs of the "a" or "mx" mechanism, e.g. a, a:example.com, mx/24, mx:example.com/24//64
+func (w *spfWalker) hostCIDRs(ctx context.Context, domain, term string) []netip.Prefix {
+	if !w.lookup(domain) {
+		return nil
+	}
+	spec, bits6, _ := strings.Cut(term, "//")
+	spec, bits4, _ := strings.Cut(spec, "/")
+	name, target, _ := strings.Cut(spec, ":")
+	if target == "" {
+		target = domain
+	}
+	if strings.Contains(target, "%") {
+		Debug("SPF record of", domain, "has macros in", term, ", skipping")
+		return nil
+	}
+
+	var cidrs []netip.Prefix
+	if strings.EqualFold(name, RecordMX) {
+		cidrs = mxCIDRs(ctx, w.resolver, target)
+	} else {
+		cidrs = DetermineCIDRs(ctx, w.resolver, target)
+	}
+	for i, cidr := range cidrs {
+		bits := bits4
+		if cidr.Addr().Is6() {
+			bits = bits6
+		}
+		if n, err := strconv.Atoi(bits); err == nil && n >= 0 && n < cidr.Bits() {
+			cidrs[i] = netip.PrefixFrom(cidr.Addr(), n).Masked()
+		}
+	}
+	return cidrs
+}
+
+// lookup counts the DNS lookup of the SPF evaluation, false means the limit is reached
+func (w *spfWalker) lookup(domain string) bool {
+	if w.lookups >= maxSPFLookups {
+		Log("WARNING: SPF record of", domain, "exceeds the limit of", maxSPFLookups, "DNS lookups, skipping the rest")
+		return false
+	}
+	w.lookups++
+	return true
+}
+
+// spfMechanism returns the lowercase mechanism name of the SPF term without the qualifier, e.g. "include" for "+include:example.com"
+func spfMechanism(term string) string {
+	term = strings.TrimLeft(term, "+-~?")
+	if i := strings.IndexAny(term, ":/"); i >= 0 {
+		term = term[:i]
+	}
+	return strings.ToLower(term)
+}
+
+// spfIPCIDRs parses the value of the ip4 or ip6 mechanism, which is an IP address or a CIDR
+func spfIPCIDRs(domain, value string) []netip.Prefix {
+	if prefix, err := netip.ParsePrefix(value); err == nil {
+		return []netip.Prefix{CanonicalPrefix(prefix, "SPF record of "+domain)}
+	}
+	if ip, err := netip.ParseAddr(value); err == nil {
+		return []netip.Prefix{addrToPrefix(ip)}
+	}
+	Log("WARNING: SPF record of", domain, "has invalid IP", value, ", skipping")
+	return nil
+}
@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestRecordEntry(t *testing.T) {
+	tests := []struct {
+		host       string
+		wantRecord string
+		wantDomain string
+		wantOK     bool
+	}{
+		{host: "srv:_matrix._tcp.example.com", wantRecord: RecordSRV, wantDomain: "_matrix._tcp.example.com", wantOK: true},
+		{host: "MX:example.com.", wantRecord: RecordMX, wantDomain: "example.com", wantOK: true},
+		{host: "spf:_spf.example.com", wantRecord: RecordSPF, wantDomain: "_spf.example.com", wantOK: true},
+		{host: "txt:example.com"},
+		{host: "mx:bad_host"},
+		{host: "example.com"},
+		{host: "fd00::1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			record, domain, ok := recordEntry(tt.host)
+			if record != tt.wantRecord || domain != tt.wantDomain || ok != tt.wantOK {
+				t.Fatalf("recordEntry() = %q, %q, %v, want %q, %q, %v", record, domain, ok, tt.wantRecord, tt.wantDomain, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestDetermineCIDRs_Records(t *testing.T) {
+	resolver := &fakeResolver{
+		ips: map[string][]string{
+			"matrix1.example.com": {"10.0.0.1"},
+			"matrix2.example.com": {"10.0.0.2", "fd00::2"},
+			"mail.example.com":    {"10.0.1.1"},
+			"example.com":         {"10.0.2.1", "fd00::1"},
+		},
+		srvs: map[string][]string{
+			"_matrix._tcp.example.com": {"matrix1.example.com.", "matrix2.example.com.", "matrix1.example.com."},
+			"_none._tcp.example.com":   {"."},
+		},
+		mxs: map[string][]string{"example.com": {"mail.example.com.", "missing.example.com."}},
+		txts: map[string][]string{
+			"example.com":          {"google-site-verification=abc", "v=spf1 ip4:192.0.2.0/24 ip6:2001:db8::/32 -ip4:198.51.100.1 include:_spf.example.com a/24 mx ~all"},
+			"_spf.example.com":     {"v=spf1 ip4:203.0.113.5 include:example.com -all"},
+			"redirect.example.com": {"v=spf1 redirect=_spf.example.com"},
+			"bad.example.com":      {"v=spf1 ip4:not-an-ip exists:%{i}.example.com"},
+		},
+	}
+	tests := []struct {
+		host string
+		want []string
+	}{
+		{host: "srv:_matrix._tcp.example.com", want: []string{"10.0.0.1/32", "10.0.0.2/32", "fd00::2/128"}},
+		{host: "srv:_none._tcp.example.com", want: []string{}},
+		{host: "srv:_missing._tcp.example.com", want: []string{}},
+		{host: "mx:example.com", want: []string{"10.0.1.1/32"}},
+		{host: "spf:example.com", want: []string{"10.0.1.1/32", "10.0.2.0/24", "192.0.2.0/24", "203.0.113.5/32", "2001:db8::/32", "fd00::1/128"}},
+		{host: "spf:redirect.example.com", want: []string{"10.0.1.1/32", "10.0.2.0/24", "192.0.2.0/24", "203.0.113.5/32", "2001:db8::/32", "fd00::1/128"}},
+		{host: "spf:bad.example.com", want: []string{}},
+		{host: "spf:missing.example.com", want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			if got := prefixStrings(DetermineCIDRs(context.Background(), resolver, tt.host)); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("DetermineCIDRs(%q) = %#v, want %#v", tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetermineCIDRs_SPFLookupLimit(t *testing.T) {
+	// each SPF record includes the next one, with more includes than the lookup limit allows
+	resolver := &fakeResolver{txts: map[string][]string{}}
+	for i := range maxSPFLookups + 5 {
+		resolver.txts[fmt.Sprintf("spf%d.example.com", i)] = []string{fmt.Sprintf("v=spf1 ip4:10.0.0.%d include:spf%d.example.com -all", i, i+1)}
+	}
+
+	got := DetermineCIDRs(context.Background(), resolver, "spf:spf0.example.com")
+	if len(got) != maxSPFLookups+1 {
+		t.Fatalf("DetermineCIDRs() = %#v, want %d CIDRs within the lookup limit", prefixStrings(got), maxSPFLookups+1)
+	}
+}
+
+func TestSPFMechanism(t *testing.T) {
+	for term, want := range map[string]string{
+		"+include:example.com": "include",
+		"-all":                 "all",
+		"a/24":                 "a",
+		"MX:example.com//64":   "mx",
+		"ip4:10.0.0.0/8":       "ip4",
+	} {
+		if got := spfMechanism(term); got != want {
+			t.Fatalf("spfMechanism(%q) = %q, want %q", term, got, want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	ProtocolUDP = "udp"
+	ProtocolTCP = "tcp"
+
+	dnsPort = "53"
+)
+
+// Resolver looks up DNS records, *net.Resolver implements it
+type Resolver interface {
+	LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error)
+	LookupCNAME(ctx context.Context, host string) (string, error)
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// netResolver is the net.Resolver that optionally treats all hostnames as fully qualified
+type netResolver struct {
+	*net.Resolver
+	noSearch bool
+}
+
+// NewResolver returns the resolver querying the nameservers (host or host:port) over the protocol (udp or tcp),
+// or the system nameservers if none are set. With noSearch, the search domains of the system config are not applied
+func NewResolver(nameservers []string, protocol string, noSearch bool) (Resolver, error) {
+	switch protocol {
+	case "", ProtocolUDP, ProtocolTCP:
+	default:
+		return nil, fmt.Errorf("unknown DNS protocol %q, must be %s or %s", protocol, ProtocolUDP, ProtocolTCP)
+	}
+	if len(nameservers) == 0 && protocol == "" {
+		return &netResolver{Resolver: net.DefaultResolver, noSearch: noSearch}, nil
+	}
+
+	addresses := make([]string, 0, len(nameservers))
+	for _, nameserver := range nameservers {
+		address, err := nameserverAddress(nameserver)
+		if err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, address)
+	}
+
+	var next atomic.Uint64
+	dialer := &net.Dialer{}
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			if len(addresses) > 0 { // rotate the nameservers, so a failed one is skipped on retries
+				address = addresses[(next.Add(1)-1)%uint64(len(addresses))]
+			}
+			if protocol != "" {
+				network = protocol
+			}
+			return dialer.DialContext(ctx, network, address)
+		},
+	}
+	return &netResolver{Resolver: resolver, noSearch: noSearch}, nil
+}
+
+// LookupNetIP looks up IP addresses of the host
+func (r *netResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	return r.Resolver.LookupNetIP(ctx, network, r.fqdn(host))
+}
+
+// LookupCNAME looks up the canonical name of the host
+func (r *netResolver) LookupCNAME(ctx context.Context, host string) (string, error) {
+	return r.Resolver.LookupCNAME(ctx, r.fqdn(host))
+}
+
+// LookupSRV looks up SRV records of the name, or of _service._proto.name if service and proto are set
+func (r *netResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	return r.Resolver.LookupSRV(ctx, service, proto, r.fqdn(name))
+}
+
+// LookupMX looks up MX records of the name
+func (r *netResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	return r.Resolver.LookupMX(ctx, r.fqdn(name))
+}
+
+// LookupTXT looks up TXT records of the name
+func (r *netResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	return r.Resolver.LookupTXT(ctx, r.fqdn(name))
+}
+
+// fqdn adds the trailing dot to the host if search domains are suppressed
+func (r *netResolver) fqdn(host string) string {
+	if r.noSearch && !strings.HasSuffix(host, ".") {
+		return host + "."
+	}
+	return host
+}
+
+// nameserverAddress returns host:port of the nameserver, using port 53 by default
+func nameserverAddress(nameserver string) (string, error) {
+	if addr, err := netip.ParseAddr(nameserver); err == nil {
+		return net.JoinHostPort(addr.String(), dnsPort), nil
+	}
+	if addrPort, err := netip.ParseAddrPort(nameserver); err == nil {
+		return addrPort.String(), nil
+	}
+	return "", fmt.Errorf("nameserver %q must be an IP address, optionally with a port", nameserver)
+}
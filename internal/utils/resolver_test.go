@@ -0,0 +1,163 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeResolver resolves hosts from the maps, without real DNS
+type fakeResolver struct {
+	ips    map[string][]string
+	cnames map[string]string
+	srvs   map[string][]string // name -> targets
+	mxs    map[string][]string // domain -> hosts
+	txts   map[string][]string
+}
+
+func (r *fakeResolver) LookupNetIP(_ context.Context, _, host string) ([]netip.Addr, error) {
+	if _, ok := r.ips[host]; !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	addrs := []netip.Addr{}
+	for _, ip := range r.ips[host] {
+		addrs = append(addrs, netip.MustParseAddr(ip))
+	}
+	return addrs, nil
+}
+
+func (r *fakeResolver) LookupCNAME(_ context.Context, host string) (string, error) {
+	if cname, ok := r.cnames[host]; ok {
+		return cname, nil
+	}
+	return "", errors.New("no CNAME")
+}
+
+func (r *fakeResolver) LookupSRV(_ context.Context, _, _, name string) (string, []*net.SRV, error) {
+	if _, ok := r.srvs[name]; !ok {
+		return "", nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	records := []*net.SRV{}
+	for _, target := range r.srvs[name] {
+		records = append(records, &net.SRV{Target: target, Port: 8448})
+	}
+	return name, records, nil
+}
+
+func (r *fakeResolver) LookupMX(_ context.Context, name string) ([]*net.MX, error) {
+	if _, ok := r.mxs[name]; !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	records := []*net.MX{}
+	for _, host := range r.mxs[name] {
+		records = append(records, &net.MX{Host: host, Pref: 10})
+	}
+	return records, nil
+}
+
+func (r *fakeResolver) LookupTXT(_ context.Context, name string) ([]string, error) {
+	if _, ok := r.txts[name]; !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return r.txts[name], nil
+}
+
+func TestDetermineCIDRs_Resolver(t *testing.T) {
+	resolver := &fakeResolver{
+		ips:    map[string][]string{"web.example.com": {"1.2.3.4", "::ffff:5.6.7.8", "fd00::1"}, "target.example.com": {"9.9.9.9"}},
+		cnames: map[string]string{"alias.example.com": "target.example.com.", "loop.example.com": "loop.example.com."},
+	}
+	tests := []struct {
+		host string
+		want []string
+	}{
+		{host: "web.example.com", want: []string{"1.2.3.4/32", "5.6.7.8/32", "fd00::1/128"}},
+		{host: "alias.example.com", want: []string{"9.9.9.9/32"}},
+		{host: "loop.example.com", want: []string{}},
+		{host: "missing.example.com", want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			if got := prefixStrings(DetermineCIDRs(context.Background(), resolver, tt.host)); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("DetermineCIDRs(%q) = %#v, want %#v", tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewResolver(t *testing.T) {
+	tests := []struct {
+		name        string
+		nameservers []string
+		protocol    string
+		wantErr     bool
+	}{
+		{name: "system"},
+		{name: "nameservers", nameservers: []string{"1.1.1.1", "[2606:4700:4700::1111]:53", "9.9.9.9:5353"}, protocol: ProtocolTCP},
+		{name: "protocol only", protocol: ProtocolUDP},
+		{name: "invalid protocol", protocol: "quic", wantErr: true},
+		{name: "invalid nameserver", nameservers: []string{"dns.example.com"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver, err := NewResolver(tt.nameservers, tt.protocol, false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewResolver() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && resolver == nil {
+				t.Fatalf("NewResolver() = nil")
+			}
+		})
+	}
+}
+
+func TestNewResolver_Nameservers(t *testing.T) {
+	// a local "nameserver" that accepts TCP connections only, to check the protocol and address are used
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer listener.Close()
+	accepted := make(chan struct{}, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		accepted <- struct{}{}
+		conn.Close()
+	}()
+
+	resolver, err := NewResolver([]string{listener.Addr().String()}, ProtocolTCP, true)
+	if err != nil {
+		t.Fatalf("NewResolver() error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if _, err := resolver.LookupNetIP(ctx, "ip4", "example.com"); err == nil {
+		t.Fatalf("LookupNetIP() expected error from the fake nameserver")
+	}
+	select {
+	case <-accepted:
+	default:
+		t.Fatalf("LookupNetIP() did not query the configured nameserver over TCP")
+	}
+}
+
+func TestNetResolver_FQDN(t *testing.T) {
+	if got := (&netResolver{noSearch: true}).fqdn("web1.internal"); got != "web1.internal." {
+		t.Fatalf("fqdn() = %q, want trailing dot", got)
+	}
+	if got := (&netResolver{noSearch: true}).fqdn("web1.internal."); got != "web1.internal." {
+		t.Fatalf("fqdn() = %q, want single trailing dot", got)
+	}
+	if got := (&netResolver{}).fqdn("web1.internal"); got != "web1.internal" {
+		t.Fatalf("fqdn() = %q, want unchanged host", got)
+	}
+}